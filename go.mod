@@ -0,0 +1,3 @@
+module github.com/roquitovalmoja/tour-of-Go-compiled
+
+go 1.24
@@ -1,10 +1,41 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/list"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"iter"
 	"math"
+	"math/big"
+	"math/bits"
+	"math/cmplx"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
+	"unsafe"
 )
 
 // func function_name( [parameter list] ) [return_types] {
@@ -21,12 +52,190 @@ func swap(x, y string) (string, string) {
 }
 
 // naked return
-func split(sum int) (x, y int) {
-	x = sum * 4 / 9
+func split(sum int) (x, y int, err error) {
+	// sum * 4 overflows for large sums, so use the checked form
+	x, err = CheckedMul(sum, 4)
+	if err != nil {
+		return
+	}
+	x = x / 9
 	y = sum - x
 	return
 }
 
+// integer overflow
+// integers have a fixed size, so x + y can wrap around silently:
+// int8(127) + 1 == -128, uint8(0) - 1 == 255
+// three ways to deal with it:
+// 1. checked - return an error instead of a wrong answer
+// 2. saturating - clamp to the largest or smallest value of the type
+// 3. wrapping - what Go does by default, spelled out on purpose
+
+// Integer is every signed and unsigned integer type, including named ones like type Celsius int
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// ErrOverflow is returned by the checked operations when the result does not fit in the type
+type ErrOverflow struct {
+	Op   string // "+", "-", "*" or "/"
+	X, Y any
+}
+
+func (e *ErrOverflow) Error() string {
+	return fmt.Sprintf("integer overflow: %v %s %v (%T)", e.X, e.Op, e.Y, e.X)
+}
+
+// ErrDivideByZero is returned by CheckedDiv when y is 0
+var ErrDivideByZero = errors.New("integer divide by zero")
+
+// limits returns the smallest and largest value of T
+func limits[T Integer]() (min, max T, signed bool) {
+	var zero T
+	width := unsafe.Sizeof(zero) * 8
+	if ^zero < 0 {
+		// signed: all ones is -1
+		max = T(1)<<(width-1) - 1
+		return -max - 1, max, true
+	}
+	return 0, ^zero, false
+}
+
+// CheckedAdd returns x + y, or an error if it overflows
+func CheckedAdd[T Integer](x, y T) (T, error) {
+	min, max, _ := limits[T]()
+	if (y > 0 && x > max-y) || (y < 0 && x < min-y) {
+		return 0, &ErrOverflow{"+", x, y}
+	}
+	return x + y, nil
+}
+
+// CheckedSub returns x - y, or an error if it overflows
+func CheckedSub[T Integer](x, y T) (T, error) {
+	min, max, signed := limits[T]()
+	if (signed && y < 0 && x > max+y) || (y > 0 && x < min+y) {
+		return 0, &ErrOverflow{"-", x, y}
+	}
+	return x - y, nil
+}
+
+// CheckedMul returns x * y, or an error if it overflows
+func CheckedMul[T Integer](x, y T) (T, error) {
+	if x == 0 || y == 0 {
+		return 0, nil
+	}
+	min, _, signed := limits[T]()
+	// min * -1 wraps back to min, and so does min / -1, so the division check below misses it
+	// (^T(0) is -1 for signed types; the constant -1 cannot be converted to an unsigned T)
+	if signed && ((x == ^T(0) && y == min) || (y == ^T(0) && x == min)) {
+		return 0, &ErrOverflow{"*", x, y}
+	}
+	p := x * y
+	if p/y != x {
+		return 0, &ErrOverflow{"*", x, y}
+	}
+	return p, nil
+}
+
+// CheckedDiv returns x / y, or an error if y is 0 or the result overflows (min / -1)
+func CheckedDiv[T Integer](x, y T) (T, error) {
+	if y == 0 {
+		return 0, ErrDivideByZero
+	}
+	min, _, signed := limits[T]()
+	if signed && x == min && y == ^T(0) {
+		return 0, &ErrOverflow{"/", x, y}
+	}
+	return x / y, nil
+}
+
+// SaturatingAdd returns x + y clamped to the range of T
+func SaturatingAdd[T Integer](x, y T) T {
+	r, err := CheckedAdd(x, y)
+	if err == nil {
+		return r
+	}
+	min, max, _ := limits[T]()
+	if y > 0 {
+		return max
+	}
+	return min
+}
+
+// SaturatingSub returns x - y clamped to the range of T
+func SaturatingSub[T Integer](x, y T) T {
+	r, err := CheckedSub(x, y)
+	if err == nil {
+		return r
+	}
+	min, max, _ := limits[T]()
+	if y > 0 {
+		return min
+	}
+	return max
+}
+
+// SaturatingMul returns x * y clamped to the range of T
+func SaturatingMul[T Integer](x, y T) T {
+	r, err := CheckedMul(x, y)
+	if err == nil {
+		return r
+	}
+	min, max, _ := limits[T]()
+	if (x < 0) != (y < 0) {
+		return min
+	}
+	return max
+}
+
+// SaturatingDiv returns x / y clamped to the range of T (min / -1 gives max)
+// like the / operator, it panics if y is 0
+func SaturatingDiv[T Integer](x, y T) T {
+	r, err := CheckedDiv(x, y)
+	if err == ErrDivideByZero {
+		panic(err)
+	}
+	if err != nil {
+		_, max, _ := limits[T]()
+		return max
+	}
+	return r
+}
+
+// WrappingAdd returns x + y modulo the size of T, which is what + already does
+func WrappingAdd[T Integer](x, y T) T { return x + y }
+
+// WrappingSub returns x - y modulo the size of T
+func WrappingSub[T Integer](x, y T) T { return x - y }
+
+// WrappingMul returns x * y modulo the size of T
+func WrappingMul[T Integer](x, y T) T { return x * y }
+
+// WrappingDiv returns x / y; min / -1 wraps to min, and y == 0 panics
+func WrappingDiv[T Integer](x, y T) T { return x / y }
+
+func integer_overflow_test() {
+	fmt.Println(CheckedAdd[int8](100, 27))                                // 127 <nil>
+	fmt.Println(CheckedAdd[int8](100, 28))                                // 0 integer overflow: 100 + 28 (int8)
+	fmt.Println(SaturatingAdd[int8](100, 28), WrappingAdd[int8](100, 28)) // 127 -128
+
+	fmt.Println(CheckedSub[uint8](0, 1))                              // 0 integer overflow: 0 - 1 (uint8)
+	fmt.Println(SaturatingSub[uint8](0, 1), WrappingSub[uint8](0, 1)) // 0 255
+
+	fmt.Println(CheckedMul[int64](math.MinInt64, -1))      // 0 integer overflow: -9223372036854775808 * -1 (int64)
+	fmt.Println(SaturatingMul[int64](math.MaxInt64/2, -3)) // -9223372036854775808
+	fmt.Println(CheckedMul[uint64](1<<32, 1<<32))          // 0 integer overflow: 4294967296 * 4294967296 (uint64)
+	fmt.Println(CheckedDiv[int16](math.MinInt16, -1))      // 0 integer overflow: -32768 / -1 (int16)
+	fmt.Println(SaturatingDiv[int16](math.MinInt16, -1))   // 32767
+	fmt.Println(WrappingDiv[int16](math.MinInt16, -1))     // -32768
+	fmt.Println(CheckedDiv(7, 0))                          // 0 integer divide by zero
+
+	// split uses CheckedMul for sum * 4
+	fmt.Println(split(20))              // 8 12 <nil>
+	fmt.Println(split(math.MaxInt / 2)) // 0 0 integer overflow: 4611686018427387903 * 4 (int)
+}
+
 func for_loop() {
 	sum := 0
 	for i := 0; i < 10; i++ {
@@ -102,319 +311,4891 @@ func compute(fn func(float64, float64) float64) float64 {
 	return fn(3, 4)
 }
 
-// function closures
-func adder() func(int) int {
-	// sum is declared outside the function
-	sum := 0
-	// putting the function inside the function
-	// the function closes over the variable sum to form a closure
-	// a closure is a function value that references variables from outside its body
-	return func(x int) int {
-		// sum is accessible inside the function
-		sum += x
-		return sum
+// numerical methods
+// compute takes a function value and calls it; the same idea scales up to a small numerics library
+// each method below takes a func(float64) float64 such as math.Cos or a closure over some data
+// and returns the answer, how many iterations it took, and an error if it gave up
+
+// SolverOptions controls the iterative methods; zero values pick the defaults
+type SolverOptions struct {
+	Tolerance     float64 // absolute tolerance on the answer; 0 means 1e-10
+	MaxIterations int     // give up after this many steps; 0 means 200
+}
+
+func (o SolverOptions) withDefaults() SolverOptions {
+	if o.Tolerance <= 0 {
+		o.Tolerance = 1e-10
+	}
+	if o.MaxIterations <= 0 {
+		o.MaxIterations = 200
 	}
+	return o
 }
 
-// structs - collection of fields
-type Vertex struct {
-	X int
-	Y int
+// ErrNotBracketed is returned when f(a) and f(b) do not have opposite signs
+type ErrNotBracketed struct {
+	A, B, FA, FB float64
 }
 
-type AnotherVertex struct {
-	X, Y float64
+func (e *ErrNotBracketed) Error() string {
+	return fmt.Sprintf("root not bracketed: f(%v) = %v and f(%v) = %v have the same sign", e.A, e.FA, e.B, e.FB)
 }
 
-// Methods - functions with a special receiver argument
-// Adding a method to AnotherVertex struct
-// methods with value receivers take either a value or a pointer as the receiver
-func (v AnotherVertex) Abs() float64 {
-	return math.Sqrt(v.X*v.X + v.Y*v.Y)
+// ErrDidNotConverge is returned when a method runs out of iterations or cannot continue
+type ErrDidNotConverge struct {
+	Method     string
+	Iterations int
+	Estimate   float64 // best answer reached
+	Reason     string
 }
 
-// Notes on methods:
-// all methods on a give ntype should have either value or pointer receivers, but not a mixture of both
+func (e *ErrDidNotConverge) Error() string {
+	return fmt.Sprintf("%s did not converge after %d iterations (estimate %v): %s", e.Method, e.Iterations, e.Estimate, e.Reason)
+}
 
-// reasons to use a pointer receiver for methods:
-// 1. to avoid copying the value on each method call
-// 2. so that the method can modify the value that its receiver points to
+// Bisection finds a root of f in [a, b] by halving the interval
+// slow but always works once f(a) and f(b) have opposite signs
+func Bisection(f func(float64) float64, a, b float64, opts SolverOptions) (float64, int, error) {
+	opts = opts.withDefaults()
+	fa, fb := f(a), f(b)
+	if fa == 0 {
+		return a, 0, nil
+	}
+	if fb == 0 {
+		return b, 0, nil
+	}
+	if math.Signbit(fa) == math.Signbit(fb) {
+		return 0, 0, &ErrNotBracketed{a, b, fa, fb}
+	}
+	for i := 1; i <= opts.MaxIterations; i++ {
+		m := a + (b-a)/2
+		fm := f(m)
+		if fm == 0 || math.Abs(b-a)/2 < opts.Tolerance { // b < a is allowed, so take the width's absolute value
+			return m, i, nil
+		}
+		if math.Signbit(fm) == math.Signbit(fa) {
+			a, fa = m, fm
+		} else {
+			b = m
+		}
+	}
+	return a + (b-a)/2, opts.MaxIterations, &ErrDidNotConverge{"bisection", opts.MaxIterations, a + (b-a)/2, "interval still too wide"}
+}
 
-// Point receivers
-// Methods with pointer receivers can modify the value to which the receiver points
-// Since methods often need to modify their receiver, pointer receivers are more common than value receivers
-// Without using * pointer receiver, you are only able to get the values from the class instance and unable to modify these values
-// With * pointer receiver, you are able to get and modify the values from the class instance
-func (v *AnotherVertex) Scale(f float64) {
-	// methods with pointer receivers take either a value or a pointer as the receiver
-	v.X = v.X * f
-	v.Y = v.Y * f
+// NewtonRoot finds a root of f near x0 using its derivative df
+// same update as NewtonSqrt: x -= f(x) / f'(x)
+func NewtonRoot(f, df func(float64) float64, x0 float64, opts SolverOptions) (float64, int, error) {
+	opts = opts.withDefaults()
+	x := x0
+	for i := 1; i <= opts.MaxIterations; i++ {
+		d := df(x)
+		if d == 0 {
+			return x, i, &ErrDidNotConverge{"newton", i, x, "zero derivative"}
+		}
+		delta := f(x) / d
+		x -= delta
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return x, i, &ErrDidNotConverge{"newton", i, x, "diverged"}
+		}
+		if math.Abs(delta) < opts.Tolerance {
+			return x, i, nil
+		}
+	}
+	return x, opts.MaxIterations, &ErrDidNotConverge{"newton", opts.MaxIterations, x, "step still too large"}
 }
 
-// function instead of method
-// error will occur if you try to use this function without pointer receiver
-// functions with a pointer agument must take a pointer
-func ScaleFunc(v *AnotherVertex, f float64) {
-	v.X = v.X * f
-	v.Y = v.Y * f
+// Brent finds a root of f in [a, b] combining bisection, secant and inverse quadratic interpolation
+// as safe as bisection, usually as fast as Newton, and needs no derivative
+func Brent(f func(float64) float64, a, b float64, opts SolverOptions) (float64, int, error) {
+	opts = opts.withDefaults()
+	fa, fb := f(a), f(b)
+	if fa == 0 {
+		return a, 0, nil
+	}
+	if fb == 0 {
+		return b, 0, nil
+	}
+	if math.Signbit(fa) == math.Signbit(fb) {
+		return 0, 0, &ErrNotBracketed{a, b, fa, fb}
+	}
+	// b is the best guess so far, a the previous one, c the other end of the bracket
+	c, fc := a, fa
+	d := b - a
+	e := d
+	for i := 1; i <= opts.MaxIterations; i++ {
+		if math.Signbit(fb) == math.Signbit(fc) {
+			c, fc = a, fa
+			d = b - a
+			e = d
+		}
+		if math.Abs(fc) < math.Abs(fb) {
+			a, b, c = b, c, b
+			fa, fb, fc = fb, fc, fb
+		}
+		tol := 2*2.2e-16*math.Abs(b) + opts.Tolerance/2
+		m := (c - b) / 2
+		if math.Abs(m) <= tol || fb == 0 {
+			return b, i, nil
+		}
+		if math.Abs(e) >= tol && math.Abs(fa) > math.Abs(fb) {
+			// try interpolation
+			var p, q float64
+			s := fb / fa
+			if a == c {
+				// secant
+				p = 2 * m * s
+				q = 1 - s
+			} else {
+				// inverse quadratic
+				q = fa / fc
+				r := fb / fc
+				p = s * (2*m*q*(q-r) - (b-a)*(r-1))
+				q = (q - 1) * (r - 1) * (s - 1)
+			}
+			if p > 0 {
+				q = -q
+			} else {
+				p = -p
+			}
+			if 2*p < math.Min(3*m*q-math.Abs(tol*q), math.Abs(e*q)) {
+				e, d = d, p/q
+			} else {
+				d, e = m, m
+			}
+		} else {
+			// fall back to bisection
+			d, e = m, m
+		}
+		a, fa = b, fb
+		if math.Abs(d) > tol {
+			b += d
+		} else {
+			b += math.Copysign(tol, m)
+		}
+		fb = f(b)
+	}
+	return b, opts.MaxIterations, &ErrDidNotConverge{"brent", opts.MaxIterations, b, "bracket still too wide"}
 }
 
-func method_sample() {
-	v := AnotherVertex{3, 4}
-	v.Scale(10)
-	fmt.Println(v.Abs())
-	ScaleFunc(&v, 10)
+// Derivative estimates f'(x) with central differences and Richardson extrapolation
+// the step h shrinks each iteration and the estimates are combined to cancel the error terms
+func Derivative(f func(float64) float64, x float64, opts SolverOptions) (float64, int, error) {
+	opts = opts.withDefaults()
+	const shrink = 1.4
+	h := 0.1 * math.Max(1, math.Abs(x))
+	n := opts.MaxIterations
+	if n > 10 {
+		n = 10 // the tableau stops improving once h gets tiny
+	}
+	// table[i][j] is the estimate with step h/shrink^i extrapolated j times
+	table := make([][]float64, n)
+	best, bestErr := 0.0, math.Inf(1)
+	for i := 0; i < n; i++ {
+		table[i] = make([]float64, i+1)
+		table[i][0] = (f(x+h) - f(x-h)) / (2 * h)
+		factor := shrink * shrink
+		for j := 1; j <= i; j++ {
+			table[i][j] = (table[i][j-1]*factor - table[i-1][j-1]) / (factor - 1)
+			factor *= shrink * shrink
+			errEst := math.Max(math.Abs(table[i][j]-table[i][j-1]), math.Abs(table[i][j]-table[i-1][j-1]))
+			if errEst < bestErr {
+				best, bestErr = table[i][j], errEst
+			}
+		}
+		if bestErr < opts.Tolerance {
+			return best, i + 1, nil
+		}
+		// once the newest estimate is much worse than the best one, rounding error has taken over
+		if i > 0 && math.Abs(table[i][i]-table[i-1][i-1]) >= 2*bestErr {
+			break
+		}
+		h /= shrink
+	}
+	if bestErr < math.Sqrt(opts.Tolerance) {
+		// good to about half the requested digits; that is as far as differencing goes
+		return best, n, nil
+	}
+	return best, n, &ErrDidNotConverge{"derivative", n, best, fmt.Sprintf("error estimate %g", bestErr)}
+}
 
-	// pointer receiver
-	// for functions instead of methods, you need to explicitly pass the pointer
-	p := &AnotherVertex{4, 3}
-	p.Scale(3)
-	ScaleFunc(p, 8)
+// Simpson integrates f over [a, b] with composite Simpson's rule on n intervals (n is rounded up to even)
+func Simpson(f func(float64) float64, a, b float64, n int) float64 {
+	if n < 2 {
+		n = 2
+	}
+	if n%2 == 1 {
+		n++
+	}
+	h := (b - a) / float64(n)
+	total := f(a) + f(b)
+	for i := 1; i < n; i++ {
+		if i%2 == 1 {
+			total += 4 * f(a+float64(i)*h)
+		} else {
+			total += 2 * f(a+float64(i)*h)
+		}
+	}
+	return total * h / 3
+}
+
+// 15-point Kronrod nodes and weights, and the 7-point Gauss weights on every other node
+var (
+	kronrodNodes = [8]float64{
+		0.991455371120812639206854697526329, 0.949107912342758524526189684047851,
+		0.864864423359769072789712788640926, 0.741531185599394439863864773280788,
+		0.586087235467691130294144845693013, 0.405845151377397166906606412076961,
+		0.207784955007898467600689403773245, 0,
+	}
+	kronrodWeights = [8]float64{
+		0.022935322010529224963732008058970, 0.063092092629978553290700663189204,
+		0.104790010322250183839876322541518, 0.140653259715525918745189590510238,
+		0.169004726639267902826583426598550, 0.190350578064785409913256402421014,
+		0.204432940075298892414161999234649, 0.209482141084727828012999174891714,
+	}
+	gaussWeights = [4]float64{
+		0.129484966168869693270611432679082, 0.279705391489276667901467771423780,
+		0.381830050505118944950369775488975, 0.417959183673469387755102040816327,
+	}
+)
 
+// gaussKronrod15 returns the Kronrod estimate over [a, b] and its difference from the Gauss estimate
+func gaussKronrod15(f func(float64) float64, a, b float64) (float64, float64) {
+	center, half := (a+b)/2, (b-a)/2
+	fc := f(center)
+	kronrod := fc * kronrodWeights[7]
+	gauss := fc * gaussWeights[3]
+	for i := 0; i < 7; i++ {
+		dx := half * kronrodNodes[i]
+		pair := f(center-dx) + f(center+dx)
+		kronrod += kronrodWeights[i] * pair
+		if i%2 == 1 {
+			gauss += gaussWeights[i/2] * pair
+		}
+	}
+	return kronrod * half, math.Abs((kronrod - gauss) * half)
 }
 
-// declaring methods on non-struct types
-type MyFloat float64
+// Integrate integrates f over [a, b] with adaptive Gauss-Kronrod quadrature
+// the interval with the largest error estimate is split in half until the total error is below the tolerance
+// the iteration count is the number of subdivisions
+func Integrate(f func(float64) float64, a, b float64, opts SolverOptions) (float64, int, error) {
+	opts = opts.withDefaults()
+	type piece struct{ a, b, value, err float64 }
+	value, err := gaussKronrod15(f, a, b)
+	pieces := []piece{{a, b, value, err}}
+	for i := 1; i <= opts.MaxIterations; i++ {
+		total, totalErr, worst := 0.0, 0.0, 0
+		for j, p := range pieces {
+			total += p.value
+			totalErr += p.err
+			if p.err > pieces[worst].err {
+				worst = j
+			}
+		}
+		if totalErr < opts.Tolerance {
+			return total, i - 1, nil
+		}
+		w := pieces[worst]
+		mid := (w.a + w.b) / 2
+		lv, le := gaussKronrod15(f, w.a, mid)
+		rv, re := gaussKronrod15(f, mid, w.b)
+		pieces[worst] = piece{w.a, mid, lv, le}
+		pieces = append(pieces, piece{mid, w.b, rv, re})
+	}
+	total, totalErr := 0.0, 0.0
+	for _, p := range pieces {
+		total += p.value
+		totalErr += p.err
+	}
+	if totalErr < opts.Tolerance {
+		return total, opts.MaxIterations, nil
+	}
+	return total, opts.MaxIterations, &ErrDidNotConverge{"gauss-kronrod", opts.MaxIterations, total, fmt.Sprintf("error estimate %g", totalErr)}
+}
 
-func (f MyFloat) Abs() float64 {
-	if f < 0 {
-		return float64(-f)
+// GoldenSection finds a minimum of f in [a, b]
+// like bisection, but the interval shrinks by the golden ratio so one point can be reused each step
+// f should have a single minimum in the interval
+func GoldenSection(f func(float64) float64, a, b float64, opts SolverOptions) (float64, int, error) {
+	opts = opts.withDefaults()
+	invPhi := (math.Sqrt(5) - 1) / 2 // 0.618...
+	c := b - invPhi*(b-a)
+	d := a + invPhi*(b-a)
+	fc, fd := f(c), f(d)
+	for i := 1; i <= opts.MaxIterations; i++ {
+		if math.Abs(b-a) < opts.Tolerance {
+			return (a + b) / 2, i, nil
+		}
+		if fc < fd {
+			b, d, fd = d, c, fc
+			c = b - invPhi*(b-a)
+			fc = f(c)
+		} else {
+			a, c, fc = c, d, fd
+			d = a + invPhi*(b-a)
+			fd = f(d)
+		}
 	}
-	return float64(f)
+	return (a + b) / 2, opts.MaxIterations, &ErrDidNotConverge{"golden section", opts.MaxIterations, (a + b) / 2, "interval still too wide"}
 }
 
-func float_test() {
-	f := MyFloat(-math.Sqrt2)
-	fmt.Println(f.Abs())
+func numerics_test() {
+	// math.Cos is a func(float64) float64 value, just like math.Pow in compute(math.Pow)
+	fmt.Println(Bisection(math.Cos, 0, 3, SolverOptions{})) // 1.5707963267632294 35 <nil>
+	fmt.Println(Brent(math.Cos, 0, 3, SolverOptions{}))     // 1.5707963267948966 7 <nil>
+
+	// closures work too: find sqrt(2) as the root of z*z - 2
+	x := 2.0
+	f := func(z float64) float64 { return z*z - x }
+	df := func(z float64) float64 { return 2 * z }
+	fmt.Println(NewtonRoot(f, df, 1, SolverOptions{})) // 1.4142135623730951 5 <nil>
+
+	fmt.Println(Derivative(math.Sin, 0, SolverOptions{})) // 0.9999999999999993 4 <nil> (cos 0 = 1)
+
+	fmt.Println(Simpson(math.Sin, 0, math.Pi, 100))               // 2.0000000108245044
+	fmt.Println(Integrate(math.Sin, 0, math.Pi, SolverOptions{})) // 2 0 <nil>
+
+	fmt.Println(GoldenSection(func(x float64) float64 { return (x - 1) * (x - 1) }, -5, 5, SolverOptions{Tolerance: 1e-8})) // 1.000000000035532 45 <nil>
+
+	// typed errors
+	_, _, err := Bisection(f, 2, 3, SolverOptions{})
+	fmt.Println(err) // root not bracketed: ...
+	_, _, err = NewtonRoot(f, df, 1, SolverOptions{MaxIterations: 2})
+	fmt.Println(err) // newton did not converge after 2 iterations ...
 }
 
-// Interfaces
-// interface type is defined as a set of method signatures
-// a value of interface type can hold any value that implements those methods
-// under the hood, interface values can be thought of as a tuple of a value and a concrete type
-// (value, type) -> holds a value of a specific underlying concrete type
-type Abser interface {
-	Abs() float64
+// expression evaluator
+// compute(hypot) and compute(math.Pow) pick the function in code
+// here the function is picked by name at run time: "hypot(5, 12) * 2 + pow(3, 4)"
+// 1. the lexer splits the string into tokens (numbers, names, operators)
+// 2. the parser turns the tokens into a tree (AST) following the precedence rules
+// 3. Eval walks the tree and calls the registered function values
+
+// CalcFunc is a registered function; Arity is the number of arguments, or -1 for any number
+type CalcFunc struct {
+	Arity int
+	Fn    func(...float64) float64
 }
 
-func interface_test() {
-	var a Abser
-	f := MyFloat(-math.Sqrt2)
-	v := AnotherVertex{3, 4}
+// Calculator holds the functions and constants an expression may use
+type Calculator struct {
+	Funcs  map[string]CalcFunc
+	Consts map[string]float64
+}
 
-	a = f  // a MyFloat implements Abser
-	a = &v // a *AnotherVertex implements Abser
+// NewCalculator returns a Calculator with hypot, pow and a few math functions registered
+func NewCalculator() *Calculator {
+	c := &Calculator{Funcs: map[string]CalcFunc{}, Consts: map[string]float64{"pi": math.Pi, "e": math.E}}
+	// the same hypot as in main, wrapped to take a variable number of arguments
+	hypot := func(x, y float64) float64 {
+		return math.Sqrt(x*x + y*y)
+	}
+	c.Register("hypot", 2, func(a ...float64) float64 { return hypot(a[0], a[1]) })
+	c.Register("pow", 2, func(a ...float64) float64 { return math.Pow(a[0], a[1]) })
+	c.Register("sqrt", 1, func(a ...float64) float64 { return math.Sqrt(a[0]) })
+	c.Register("abs", 1, func(a ...float64) float64 { return math.Abs(a[0]) })
+	c.Register("sin", 1, func(a ...float64) float64 { return math.Sin(a[0]) })
+	c.Register("cos", 1, func(a ...float64) float64 { return math.Cos(a[0]) })
+	c.Register("max", -1, func(a ...float64) float64 {
+		m := math.Inf(-1)
+		for _, v := range a {
+			m = math.Max(m, v)
+		}
+		return m
+	})
+	c.Register("min", -1, func(a ...float64) float64 {
+		m := math.Inf(1)
+		for _, v := range a {
+			m = math.Min(m, v)
+		}
+		return m
+	})
+	return c
+}
 
-	// In the following line, v is a AnotherVertex (not *AnotherVertex)
-	// and does NOT implement Abser
-	// a = v
-	// AnotherVertex type (not a pointer) does not implement Abser because Abs() is defined only on *AnotherVertex (pointer)
-	// it will depend on how you define the method
+// Register adds or replaces the function called name
+func (c *Calculator) Register(name string, arity int, fn func(...float64) float64) {
+	c.Funcs[name] = CalcFunc{arity, fn}
+}
 
-	fmt.Println(a.Abs())
+// Eval parses and evaluates src
+func (c *Calculator) Eval(src string) (float64, error) {
+	e, err := ParseExpr(src)
+	if err != nil {
+		return 0, err
+	}
+	return e.Eval(c)
 }
 
-// Implicit declaration of interface
-// no need to explicitly declare that it implements the interface
-// calling a method on a nil interface is a run-time error because there is no type inside the interface tuple to indicate which concrete method to call
-// an empty interface may hold values of any type
-// var i interface{} - empty interface -> (<nil>, <nil>)
-// i = 42 -> (42, int)
-// i = "hello" -> ("hello", string)
+// CalcError is a syntax or evaluation error at a column of the input (1-based)
+type CalcError struct {
+	Col int
+	Msg string
+}
 
-type I interface {
-	M()
+func (e *CalcError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Col, e.Msg)
 }
-type T struct {
-	S string
+
+// Expr is a node of the expression tree
+type Expr interface {
+	Eval(c *Calculator) (float64, error)
+	String() string
 }
 
-// This method means type T implements the interface I,
-// but we don't need to explicitly declare that it does so.
-// This is called implicit implementation.
-func (t T) M() {
-	fmt.Println(t.S)
+// NumberExpr is a literal like 3 or 2.5e3
+type NumberExpr struct {
+	Value float64
 }
 
-func implicit_interface_test() {
-	var i I = T{"hello"}
-	i.M()
+// NameExpr is a constant like pi
+type NameExpr struct {
+	Name string
+	Col  int
 }
 
-func describe(i I) {
-	fmt.Printf("(%v, %T)\n", i, i)
+// UnaryExpr is -x or +x
+type UnaryExpr struct {
+	Op byte
+	X  Expr
 }
 
-// Handling nil interface values
-// nil interface values behave like nil pointers
-// calling a method on a nil interface is a run-time error because there is no type inside the interface tuple to indicate which concrete method to call
-func (t *T) M2() {
-	// common way to handle nil receivers while in some languages, this would trigger a null pointer exception
-	if t == nil {
-		fmt.Println("<nil>")
-		return
+// BinaryExpr is x op y for op in + - * / ^
+type BinaryExpr struct {
+	Op   byte
+	X, Y Expr
+}
+
+// CallExpr is name(args...)
+type CallExpr struct {
+	Name string
+	Args []Expr
+	Col  int
+}
+
+func (e *NumberExpr) Eval(c *Calculator) (float64, error) { return e.Value, nil }
+func (e *NumberExpr) String() string                      { return strconv.FormatFloat(e.Value, 'g', -1, 64) }
+
+func (e *NameExpr) Eval(c *Calculator) (float64, error) {
+	v, ok := c.Consts[e.Name]
+	if !ok {
+		return 0, &CalcError{e.Col, fmt.Sprintf("unknown name %q", e.Name)}
 	}
-	fmt.Println(t.S)
+	return v, nil
 }
+func (e *NameExpr) String() string { return e.Name }
 
-func nil_interface_test() {
-	var i I  // nil interface
-	var t *T // nil pointer
-	i = t
-	describe(i) // (<nil>, *main.T)
-	i.M2()      // <nil>
+func (e *UnaryExpr) Eval(c *Calculator) (float64, error) {
+	x, err := e.X.Eval(c)
+	if e.Op == '-' {
+		x = -x
+	}
+	return x, err
+}
+func (e *UnaryExpr) String() string { return fmt.Sprintf("(%c%v)", e.Op, e.X) }
 
-	i = &T{"hello"} // non-nil interface
-	describe(i)     // (&{hello}, *main.T)
-	i.M2()          // hello
+func (e *BinaryExpr) Eval(c *Calculator) (float64, error) {
+	x, err := e.X.Eval(c)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.Y.Eval(c)
+	if err != nil {
+		return 0, err
+	}
+	switch e.Op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	case '/':
+		return x / y, nil
+	default: // '^'
+		return math.Pow(x, y), nil
+	}
 }
+func (e *BinaryExpr) String() string { return fmt.Sprintf("(%v %c %v)", e.X, e.Op, e.Y) }
 
-// Type assertions
-// provides access to an interface value's underlying concrete value
-// t := i.(T) -> asserts that the interface value i holds the concrete type T and assigns the underlying T value to the variable t
-// t, ok := i.(T) -> checks whether the interface value i holds the concrete type T
-func type_assertion_test() {
-	var i interface{} = "hello"
+func (e *CallExpr) Eval(c *Calculator) (float64, error) {
+	f, ok := c.Funcs[e.Name]
+	if !ok {
+		return 0, &CalcError{e.Col, fmt.Sprintf("unknown function %q", e.Name)}
+	}
+	if f.Arity >= 0 && len(e.Args) != f.Arity {
+		return 0, &CalcError{e.Col, fmt.Sprintf("%s takes %d arguments, got %d", e.Name, f.Arity, len(e.Args))}
+	}
+	args := make([]float64, len(e.Args))
+	for i, a := range e.Args {
+		v, err := a.Eval(c)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return f.Fn(args...), nil
+}
+func (e *CallExpr) String() string {
+	args := make([]string, len(e.Args))
+	for i, a := range e.Args {
+		args[i] = a.String()
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokNumber
+	tokName
+	tokOp // + - * / ^ ( ) ,
+)
+
+type calcToken struct {
+	kind int
+	text string
+	col  int
+}
+
+func lexExpr(src string) ([]calcToken, error) {
+	var toks []calcToken
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case strings.IndexByte("+-*/^(),", ch) >= 0:
+			toks = append(toks, calcToken{tokOp, string(ch), i + 1})
+			i++
+		case ch >= '0' && ch <= '9' || ch == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			// exponent: 1e3, 2.5E-4
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && src[k] >= '0' && src[k] <= '9' {
+					for k < len(src) && src[k] >= '0' && src[k] <= '9' {
+						k++
+					}
+					j = k
+				}
+			}
+			if _, err := strconv.ParseFloat(src[i:j], 64); err != nil {
+				return nil, &CalcError{i + 1, fmt.Sprintf("bad number %q", src[i:j])}
+			}
+			toks = append(toks, calcToken{tokNumber, src[i:j], i + 1})
+			i = j
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, calcToken{tokName, src[i:j], i + 1})
+			i = j
+		default:
+			return nil, &CalcError{i + 1, fmt.Sprintf("unexpected character %q", ch)}
+		}
+	}
+	return append(toks, calcToken{tokEOF, "", len(src) + 1}), nil
+}
+
+// exprParser is a recursive descent parser; each method handles one precedence level
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = ("-" | "+") unary | power
+//	power   = primary [ "^" unary ]        (right associative: 2^3^2 = 2^9)
+//	primary = number | name | name "(" [ expr { "," expr } ] ")" | "(" expr ")"
+type exprParser struct {
+	toks []calcToken
+	pos  int
+}
+
+// ParseExpr parses src into an expression tree
+func ParseExpr(src string) (Expr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &CalcError{t.col, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return e, nil
+}
+
+func (p *exprParser) peek() calcToken { return p.toks[p.pos] }
+
+func (p *exprParser) next() calcToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isOp(ops string) bool {
+	t := p.peek()
+	return t.kind == tokOp && strings.Contains(ops, t.text)
+}
+
+func (p *exprParser) expr() (Expr, error) {
+	x, err := p.term()
+	for err == nil && p.isOp("+-") {
+		op := p.next().text[0]
+		var y Expr
+		y, err = p.term()
+		x = &BinaryExpr{op, x, y}
+	}
+	return x, err
+}
+
+func (p *exprParser) term() (Expr, error) {
+	x, err := p.unary()
+	for err == nil && p.isOp("*/") {
+		op := p.next().text[0]
+		var y Expr
+		y, err = p.unary()
+		x = &BinaryExpr{op, x, y}
+	}
+	return x, err
+}
+
+func (p *exprParser) unary() (Expr, error) {
+	if p.isOp("+-") {
+		op := p.next().text[0]
+		x, err := p.unary()
+		return &UnaryExpr{op, x}, err
+	}
+	return p.power()
+}
+
+func (p *exprParser) power() (Expr, error) {
+	x, err := p.primary()
+	if err == nil && p.isOp("^") {
+		p.next()
+		var y Expr
+		y, err = p.unary()
+		x = &BinaryExpr{'^', x, y}
+	}
+	return x, err
+}
+
+func (p *exprParser) primary() (Expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return &NumberExpr{v}, nil
+	case t.kind == tokName && p.isOp("("):
+		p.next()
+		call := &CallExpr{Name: t.text, Col: t.col}
+		if p.isOp(")") {
+			p.next()
+			return call, nil
+		}
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			if p.isOp(")") {
+				p.next()
+				return call, nil
+			}
+			if !p.isOp(",") {
+				return nil, p.unexpected("expected ',' or ')'")
+			}
+			p.next()
+		}
+	case t.kind == tokName:
+		return &NameExpr{t.text, t.col}, nil
+	case t.kind == tokOp && t.text == "(":
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.unexpected("expected ')'")
+		}
+		p.next()
+		return x, nil
+	}
+	if t.kind != tokEOF {
+		p.pos-- // report the token we just consumed
+	}
+	return nil, p.unexpected("expected a number, name or '('")
+}
+
+func (p *exprParser) unexpected(want string) error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return &CalcError{t.col, "unexpected end of input, " + want}
+	}
+	return &CalcError{t.col, fmt.Sprintf("unexpected %q, %s", t.text, want)}
+}
+
+// calc_repl reads one expression per line and prints its value
+// errors point at the column with a caret
+func calc_repl(in io.Reader, out io.Writer) {
+	c := NewCalculator()
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(out, "> ")
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) != "" {
+			v, err := c.Eval(line)
+			var ce *CalcError
+			if errors.As(err, &ce) {
+				fmt.Fprintf(out, "  %s^\n  %v\n", strings.Repeat(" ", ce.Col-1), err)
+			} else if err != nil {
+				fmt.Fprintf(out, "  %v\n", err)
+			} else {
+				fmt.Fprintln(out, v)
+			}
+		}
+		fmt.Fprint(out, "> ")
+	}
+	fmt.Fprintln(out)
+}
+
+func calc_test() {
+	c := NewCalculator()
+	fmt.Println(c.Eval("hypot(5, 12) * 2 + pow(3, 4)")) // 107 <nil>
+	fmt.Println(c.Eval("-2^2"))                         // -4 <nil>
+	fmt.Println(c.Eval("2^3^2"))                        // 512 <nil>
+
+	// register any func(...float64) float64 value
+	c.Register("avg", -1, func(a ...float64) float64 {
+		total := 0.0
+		for _, v := range a {
+			total += v
+		}
+		return total / float64(len(a))
+	})
+	fmt.Println(c.Eval("avg(1, 2, 3, 4) * pi")) // 7.853981633974483 <nil>
+
+	e, _ := ParseExpr("hypot(5, 12) * 2 + pow(3, 4)")
+	fmt.Println(e) // ((hypot(5, 12) * 2) + pow(3, 4))
+
+	// errors carry the column
+	fmt.Println(c.Eval("1 + * 2"))    // 0 column 5: unexpected "*", expected a number, name or '('
+	fmt.Println(c.Eval("hypot(3, 4")) // 0 column 11: unexpected end of input, expected ',' or ')'
+	fmt.Println(c.Eval("foo(1)"))     // 0 column 1: unknown function "foo"
+	fmt.Println(c.Eval("pow(2)"))     // 0 column 1: pow takes 2 arguments, got 1
+}
+
+// function closures
+func adder() func(int) int {
+	// sum is declared outside the function
+	sum := 0
+	// putting the function inside the function
+	// the function closes over the variable sum to form a closure
+	// a closure is a function value that references variables from outside its body
+	return func(x int) int {
+		// sum is accessible inside the function
+		sum += x
+		return sum
+	}
+}
+
+// more closures
+// adder keeps a running sum in the variable it closes over
+// the same trick gives running min/max, mean, moving averages and windowed sums:
+// the closure owns its state and each call adds a value and returns the current result
+// a closure is not safe to call from several goroutines at once,
+// so each one is also available as an Accumulator that guards its state with a mutex (like SafeCounter)
+
+// accumulator is the state shared by the closure and the Accumulator forms
+type accumulator interface {
+	add(x float64, now time.Time)
+	value(now time.Time) float64
+}
+
+type extremeAcc struct {
+	v     float64
+	seen  bool
+	isMax bool
+}
+
+func (a *extremeAcc) add(x float64, _ time.Time) {
+	if !a.seen || (a.isMax && x > a.v) || (!a.isMax && x < a.v) {
+		a.v, a.seen = x, true
+	}
+}
+func (a *extremeAcc) value(time.Time) float64 { return a.v }
+
+type meanAcc struct {
+	n    int
+	mean float64
+}
+
+func (a *meanAcc) add(x float64, _ time.Time) {
+	a.n++
+	a.mean += (x - a.mean) / float64(a.n)
+}
+func (a *meanAcc) value(time.Time) float64 { return a.mean }
+
+// ewmaAcc is an exponentially weighted moving average:
+// avg = alpha*x + (1-alpha)*avg, so older values fade away geometrically
+type ewmaAcc struct {
+	alpha float64
+	avg   float64
+	seen  bool
+}
+
+func (a *ewmaAcc) add(x float64, _ time.Time) {
+	if !a.seen {
+		a.avg, a.seen = x, true
+		return
+	}
+	a.avg = a.alpha*x + (1-a.alpha)*a.avg
+}
+func (a *ewmaAcc) value(time.Time) float64 { return a.avg }
+
+// windowAcc sums the last n values using a ring buffer
+type windowAcc struct {
+	ring []float64
+	next int
+	sum  float64
+}
+
+func (a *windowAcc) add(x float64, _ time.Time) {
+	if len(a.ring) == 0 {
+		return
+	}
+	a.sum += x - a.ring[a.next] // the slot being overwritten drops out of the window
+	a.ring[a.next] = x
+	a.next = (a.next + 1) % len(a.ring)
+	if a.next == 0 {
+		// recompute now and then so rounding errors from the subtractions cannot pile up
+		a.sum = 0
+		for _, v := range a.ring {
+			a.sum += v
+		}
+	}
+}
+func (a *windowAcc) value(time.Time) float64 { return a.sum }
+
+type timedValue struct {
+	at time.Time
+	x  float64
+}
+
+// timeWindowAcc sums the values added in the last d; perSecond turns it into a rate
+type timeWindowAcc struct {
+	d         time.Duration
+	values    []timedValue
+	sum       float64
+	perSecond bool
+}
+
+func (a *timeWindowAcc) expire(now time.Time) {
+	i := 0
+	for i < len(a.values) && now.Sub(a.values[i].at) >= a.d {
+		a.sum -= a.values[i].x
+		i++
+	}
+	a.values = a.values[i:]
+	if len(a.values) == 0 {
+		a.sum = 0
+	}
+}
+
+func (a *timeWindowAcc) add(x float64, now time.Time) {
+	a.expire(now)
+	a.values = append(a.values, timedValue{now, x})
+	a.sum += x
+}
+
+func (a *timeWindowAcc) value(now time.Time) float64 {
+	a.expire(now)
+	if a.perSecond {
+		return a.sum / a.d.Seconds()
+	}
+	return a.sum
+}
+
+// as_closure turns an accumulator into an adder-style closure
+func as_closure(a accumulator, now func() time.Time) func(float64) float64 {
+	if now == nil {
+		now = time.Now
+	}
+	return func(x float64) float64 {
+		t := now()
+		a.add(x, t)
+		return a.value(t)
+	}
+}
+
+// running_min returns a closure that returns the smallest value seen so far
+func running_min() func(float64) float64 { return as_closure(&extremeAcc{}, nil) }
+
+// running_max returns a closure that returns the largest value seen so far
+func running_max() func(float64) float64 { return as_closure(&extremeAcc{isMax: true}, nil) }
+
+// running_mean returns a closure that returns the mean of the values seen so far
+func running_mean() func(float64) float64 { return as_closure(&meanAcc{}, nil) }
+
+// the constructors below check their arguments, so a bad one is an error up front
+// instead of a panic in make, a division by zero or an average that never settles
+
+func newEWMAAcc(alpha float64) (*ewmaAcc, error) {
+	if !(alpha > 0 && alpha <= 1) { // also rejects NaN
+		return nil, fmt.Errorf("ewma alpha %v is not in (0, 1]", alpha)
+	}
+	return &ewmaAcc{alpha: alpha}, nil
+}
+
+func newWindowAcc(n int) (*windowAcc, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("window size %d is not positive", n)
+	}
+	return &windowAcc{ring: make([]float64, n)}, nil
+}
+
+func newTimeWindowAcc(d time.Duration, perSecond bool) (*timeWindowAcc, error) {
+	if d <= 0 {
+		return nil, fmt.Errorf("time window %v is not positive", d)
+	}
+	return &timeWindowAcc{d: d, perSecond: perSecond}, nil
+}
+
+// ewma returns a closure for an exponentially weighted moving average; alpha must be in (0, 1]
+func ewma(alpha float64) (func(float64) float64, error) {
+	a, err := newEWMAAcc(alpha)
+	if err != nil {
+		return nil, err
+	}
+	return as_closure(a, nil), nil
+}
+
+// window_sum returns a closure that sums the last n values; n must be positive
+func window_sum(n int) (func(float64) float64, error) {
+	a, err := newWindowAcc(n)
+	if err != nil {
+		return nil, err
+	}
+	return as_closure(a, nil), nil
+}
+
+// time_window_sum returns a closure that sums the values added in the last d; d must be positive
+// now is the clock to use; nil means time.Now
+func time_window_sum(d time.Duration, now func() time.Time) (func(float64) float64, error) {
+	a, err := newTimeWindowAcc(d, false)
+	if err != nil {
+		return nil, err
+	}
+	return as_closure(a, now), nil
+}
+
+// rate_per_second returns a closure that returns the values added in the last d, per second; d must be positive
+func rate_per_second(d time.Duration, now func() time.Time) (func(float64) float64, error) {
+	a, err := newTimeWindowAcc(d, true)
+	if err != nil {
+		return nil, err
+	}
+	return as_closure(a, now), nil
+}
+
+// Accumulator is the concurrency-safe form of the closures above
+type Accumulator struct {
+	mu  sync.Mutex
+	acc accumulator
+	now func() time.Time
+}
+
+func newAccumulator(a accumulator, now func() time.Time) *Accumulator {
+	if now == nil {
+		now = time.Now
+	}
+	return &Accumulator{acc: a, now: now}
+}
+
+func NewRunningMin() *Accumulator  { return newAccumulator(&extremeAcc{}, nil) }
+func NewRunningMax() *Accumulator  { return newAccumulator(&extremeAcc{isMax: true}, nil) }
+func NewRunningMean() *Accumulator { return newAccumulator(&meanAcc{}, nil) }
+
+// NewEWMA, NewWindowSum, NewTimeWindowSum and NewRatePerSecond check their arguments like the closures do
+func NewEWMA(alpha float64) (*Accumulator, error) {
+	a, err := newEWMAAcc(alpha)
+	if err != nil {
+		return nil, err
+	}
+	return newAccumulator(a, nil), nil
+}
+func NewWindowSum(n int) (*Accumulator, error) {
+	a, err := newWindowAcc(n)
+	if err != nil {
+		return nil, err
+	}
+	return newAccumulator(a, nil), nil
+}
+func NewTimeWindowSum(d time.Duration, now func() time.Time) (*Accumulator, error) {
+	a, err := newTimeWindowAcc(d, false)
+	if err != nil {
+		return nil, err
+	}
+	return newAccumulator(a, now), nil
+}
+func NewRatePerSecond(d time.Duration, now func() time.Time) (*Accumulator, error) {
+	a, err := newTimeWindowAcc(d, true)
+	if err != nil {
+		return nil, err
+	}
+	return newAccumulator(a, now), nil
+}
+
+// Add adds x and returns the new value
+func (a *Accumulator) Add(x float64) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.now()
+	a.acc.add(x, t)
+	return a.acc.value(t)
+}
+
+// Value returns the current value; time-based accumulators drop expired values first
+func (a *Accumulator) Value() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.acc.value(a.now())
+}
+
+func accumulator_test() {
+	lo, hi, avg := running_min(), running_max(), running_mean()
+	for _, x := range []float64{3, 1, 4, 1, 5} {
+		fmt.Println(lo(x), hi(x), avg(x))
+	}
+	// last line: 1 5 2.8
+
+	smooth, _ := ewma(0.5)
+	fmt.Println(smooth(10), smooth(20), smooth(20)) // 10 15 17.5
+
+	last3, _ := window_sum(3)
+	fmt.Println(last3(1), last3(2), last3(3), last3(4)) // 1 3 6 9
+
+	// bad arguments are reported instead of panicking later
+	_, err := window_sum(0)
+	fmt.Println(err) // window size 0 is not positive
+	_, err = ewma(1.5)
+	fmt.Println(err) // ewma alpha 1.5 is not in (0, 1]
+
+	// a fake clock makes time windows easy to follow
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	recent, _ := time_window_sum(time.Minute, now)
+	rate, _ := rate_per_second(10*time.Second, now)
+	fmt.Println(recent(5), rate(20)) // 5 2
+	clock = clock.Add(30 * time.Second)
+	fmt.Println(recent(5), rate(30)) // 10 3
+	clock = clock.Add(45 * time.Second)
+	fmt.Println(recent(1), rate(0)) // 6 0 - the first 5 is older than a minute
+
+	// the Accumulator form can be shared between goroutines
+	total := NewRunningMean()
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(x float64) {
+			defer wg.Done()
+			total.Add(x)
+		}(float64(i))
+	}
+	wg.Wait()
+	fmt.Println(total.Value()) // 50.5
+}
+
+// memoisation
+// a pure function always returns the same result for the same arguments,
+// so the result can be cached in a map keyed by the arguments
+// Memo adds the things a real cache needs:
+// - a capacity, evicting the least recently used entry (container/list keeps the order)
+// - an optional time to live
+// - hit/miss counters
+// - single-flight: if several goroutines ask for the same key at once, fn runs once and they all share the result
+
+// MemoOptions configures a Memo; zero values mean unbounded, no expiry and the real clock
+type MemoOptions struct {
+	Capacity int              // maximum number of cached results; 0 means no limit
+	TTL      time.Duration    // how long a result stays valid; 0 means forever
+	Now      func() time.Time // clock for TTL; nil means time.Now
+}
+
+// MemoStats counts cache activity
+type MemoStats struct {
+	Hits, Misses, Evictions int
+}
+
+type memoEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// memoCall is a computation in progress; done is closed when it finishes
+type memoCall[V any] struct {
+	done      chan struct{}
+	value     V
+	panicked  bool
+	recovered any
+}
+
+// Memo caches the results of fn
+type Memo[K comparable, V any] struct {
+	mu       sync.Mutex
+	fn       func(K) V
+	opts     MemoOptions
+	items    map[K]*list.Element // element values are *memoEntry[K, V]
+	order    *list.List          // most recently used at the front
+	inflight map[K]*memoCall[V]
+	stats    MemoStats
+}
+
+// NewMemo returns a Memo for fn
+func NewMemo[K comparable, V any](fn func(K) V, opts MemoOptions) *Memo[K, V] {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Memo[K, V]{
+		fn:       fn,
+		opts:     opts,
+		items:    make(map[K]*list.Element),
+		order:    list.New(),
+		inflight: make(map[K]*memoCall[V]),
+	}
+}
+
+// Get returns fn(key), computing it only if it is not cached
+func (m *Memo[K, V]) Get(key K) V {
+	m.mu.Lock()
+	if el, ok := m.items[key]; ok {
+		e := el.Value.(*memoEntry[K, V])
+		if m.opts.TTL == 0 || m.opts.Now().Before(e.expires) {
+			m.stats.Hits++
+			m.order.MoveToFront(el)
+			m.mu.Unlock()
+			return e.value
+		}
+		// expired
+		m.order.Remove(el)
+		delete(m.items, key)
+	}
+	if c, ok := m.inflight[key]; ok {
+		// someone else is computing it; wait for them
+		m.stats.Hits++
+		m.mu.Unlock()
+		<-c.done
+		if c.panicked {
+			panic(c.recovered)
+		}
+		return c.value
+	}
+	m.stats.Misses++
+	c := &memoCall[V]{done: make(chan struct{})}
+	m.inflight[key] = c
+	m.mu.Unlock()
+
+	// fn runs without the lock held, so it may call Get recursively (see memo_test)
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.panicked, c.recovered = true, r
+			}
+		}()
+		c.value = m.fn(key)
+	}()
+
+	m.mu.Lock()
+	delete(m.inflight, key)
+	if !c.panicked {
+		m.store(key, c.value)
+	}
+	m.mu.Unlock()
+	close(c.done)
+	if c.panicked {
+		panic(c.recovered)
+	}
+	return c.value
+}
+
+// store adds a result and evicts the least recently used entry if over capacity; m.mu must be held
+func (m *Memo[K, V]) store(key K, value V) {
+	e := &memoEntry[K, V]{key: key, value: value}
+	if m.opts.TTL > 0 {
+		e.expires = m.opts.Now().Add(m.opts.TTL)
+	}
+	m.items[key] = m.order.PushFront(e)
+	if m.opts.Capacity > 0 && m.order.Len() > m.opts.Capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoEntry[K, V]).key)
+		m.stats.Evictions++
+	}
+}
+
+// Stats returns the hit, miss and eviction counts
+func (m *Memo[K, V]) Stats() MemoStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Len returns the number of cached results
+func (m *Memo[K, V]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// Memoize wraps fn so results are cached
+func Memoize[K comparable, V any](fn func(K) V, opts MemoOptions) func(K) V {
+	return NewMemo(fn, opts).Get
+}
+
+// Memoize2 does the same for two-argument functions like hypot; the pair of arguments is the key
+func Memoize2[A, B comparable, V any](fn func(A, B) V, opts MemoOptions) func(A, B) V {
+	type args struct {
+		a A
+		b B
+	}
+	m := NewMemo(func(k args) V { return fn(k.a, k.b) }, opts)
+	return func(a A, b B) V { return m.Get(args{a, b}) }
+}
+
+func memo_test() {
+	calls := 0
+	hypot := func(x, y float64) float64 {
+		calls++
+		return math.Sqrt(x*x + y*y)
+	}
+	cached := Memoize2(hypot, MemoOptions{Capacity: 2})
+	fmt.Println(cached(3, 4), cached(3, 4), cached(5, 12), calls) // 5 5 13 2
+	fmt.Println(compute(cached), calls)                           // 5 2 - compute calls fn(3, 4), already cached
+
+	// recursive: fib refers to the memoised version of itself, so every F(k) is computed once
+	var fib *Memo[int, *big.Int]
+	fib = NewMemo(func(n int) *big.Int {
+		if n < 2 {
+			return big.NewInt(int64(n))
+		}
+		return new(big.Int).Add(fib.Get(n-1), fib.Get(n-2))
+	}, MemoOptions{})
+	fmt.Println(fib.Get(100)) // 354224848179261915075
+	fmt.Println(fib.Stats())  // {98 101 0}
+
+	// single-flight: 10 goroutines, one slow computation
+	slowCalls := 0
+	slow := NewMemo(func(k string) int {
+		slowCalls++ // only ever runs once per key, so no race
+		time.Sleep(50 * time.Millisecond)
+		return len(k)
+	}, MemoOptions{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slow.Get("hello")
+		}()
+	}
+	wg.Wait()
+	fmt.Println(slowCalls, slow.Stats()) // 1 {9 1 0}
+
+	// TTL with a fake clock
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ttl := NewMemo(strings.ToUpper, MemoOptions{TTL: time.Minute, Now: func() time.Time { return clock }})
+	ttl.Get("go")
+	ttl.Get("go")
+	clock = clock.Add(2 * time.Minute)
+	ttl.Get("go")
+	fmt.Println(ttl.Stats()) // {1 2 0}
+}
+
+// structs - collection of fields
+type Vertex struct {
+	X int
+	Y int
+}
+
+type AnotherVertex struct {
+	X, Y float64
+}
+
+// Methods - functions with a special receiver argument
+// Adding a method to AnotherVertex struct
+// methods with value receivers take either a value or a pointer as the receiver
+func (v AnotherVertex) Abs() float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y)
+}
+
+// Notes on methods:
+// all methods on a give ntype should have either value or pointer receivers, but not a mixture of both
+
+// reasons to use a pointer receiver for methods:
+// 1. to avoid copying the value on each method call
+// 2. so that the method can modify the value that its receiver points to
+
+// Point receivers
+// Methods with pointer receivers can modify the value to which the receiver points
+// Since methods often need to modify their receiver, pointer receivers are more common than value receivers
+// Without using * pointer receiver, you are only able to get the values from the class instance and unable to modify these values
+// With * pointer receiver, you are able to get and modify the values from the class instance
+func (v *AnotherVertex) Scale(f float64) {
+	// methods with pointer receivers take either a value or a pointer as the receiver
+	v.X = v.X * f
+	v.Y = v.Y * f
+}
+
+// function instead of method
+// error will occur if you try to use this function without pointer receiver
+// functions with a pointer agument must take a pointer
+func ScaleFunc(v *AnotherVertex, f float64) {
+	v.X = v.X * f
+	v.Y = v.Y * f
+}
+
+func method_sample() {
+	v := AnotherVertex{3, 4}
+	v.Scale(10)
+	fmt.Println(v.Abs())
+	ScaleFunc(&v, 10)
+
+	// pointer receiver
+	// for functions instead of methods, you need to explicitly pass the pointer
+	p := &AnotherVertex{4, 3}
+	p.Scale(3)
+	ScaleFunc(p, 8)
+
+}
+
+// declaring methods on non-struct types
+type MyFloat float64
+
+func (f MyFloat) Abs() float64 {
+	if f < 0 {
+		return float64(-f)
+	}
+	return float64(f)
+}
+
+func float_test() {
+	f := MyFloat(-math.Sqrt2)
+	fmt.Println(f.Abs())
+}
+
+// Interfaces
+// interface type is defined as a set of method signatures
+// a value of interface type can hold any value that implements those methods
+// under the hood, interface values can be thought of as a tuple of a value and a concrete type
+// (value, type) -> holds a value of a specific underlying concrete type
+type Abser interface {
+	Abs() float64
+}
+
+func interface_test() {
+	var a Abser
+	f := MyFloat(-math.Sqrt2)
+	v := AnotherVertex{3, 4}
+
+	a = f  // a MyFloat implements Abser
+	a = &v // a *AnotherVertex implements Abser
+
+	// In the following line, v is a AnotherVertex (not *AnotherVertex)
+	// and does NOT implement Abser
+	// a = v
+	// AnotherVertex type (not a pointer) does not implement Abser because Abs() is defined only on *AnotherVertex (pointer)
+	// it will depend on how you define the method
+
+	fmt.Println(a.Abs())
+}
+
+// Method sets, checked by the compiler's own type checker
+// go/parser reads the source into syntax trees and go/types works out every type, exactly like the compiler
+// types.NewMethodSet(T) lists the methods callable on a value of type T:
+// - the method set of T has only the value-receiver methods
+// - the method set of *T has both value- and pointer-receiver methods
+// a type implements an interface when its method set contains every interface method
+// go run test.go explain AnotherVertex Abser [dir]
+
+// Explain loads the package in dir and reports whether typeName and *typeName implement ifaceName
+// ifaceName may be qualified with an imported package name, e.g. fmt.Stringer
+func Explain(dir, typeName, ifaceName string) (string, error) {
+	lp, err := loadPackage(dir)
+	if err != nil {
+		return "", err
+	}
+	pkg := lp.pkg
+	tn, ok := pkg.Scope().Lookup(typeName).(*types.TypeName)
+	if !ok {
+		return "", fmt.Errorf("no type %s in package %s", typeName, pkg.Name())
+	}
+	iface, err := lookupInterface(pkg, ifaceName)
+	if err != nil {
+		return "", err
+	}
+
+	qual := types.RelativeTo(pkg)
+	t := tn.Type()
+	pt := types.NewPointer(t)
+	valueSet, pointerSet := types.NewMethodSet(t), types.NewMethodSet(pt)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "method set of %s:\n%s", types.TypeString(t, qual), methodList(valueSet, qual))
+	fmt.Fprintf(&b, "method set of %s:\n%s", types.TypeString(pt, qual), methodList(pointerSet, qual))
+	fmt.Fprintf(&b, "interface %s requires:\n", ifaceName)
+	for i := 0; i < iface.NumMethods(); i++ {
+		fmt.Fprintf(&b, "    %s\n", funcString(iface.Method(i), qual))
+	}
+
+	for _, typ := range []types.Type{t, pt} {
+		name := types.TypeString(typ, qual)
+		if types.Implements(typ, iface) {
+			fmt.Fprintf(&b, "%s implements %s\n", name, ifaceName)
+			continue
+		}
+		fmt.Fprintf(&b, "%s does NOT implement %s:\n", name, ifaceName)
+		for i := 0; i < iface.NumMethods(); i++ {
+			m := iface.Method(i)
+			obj, _, _ := types.LookupFieldOrMethod(typ, true, pkg, m.Name())
+			fn, isFunc := obj.(*types.Func)
+			switch {
+			case !isFunc:
+				fmt.Fprintf(&b, "    missing method %s\n", m.Name())
+			case !types.Identical(withoutRecv(fn), withoutRecv(m)):
+				fmt.Fprintf(&b, "    wrong signature: have %s, want %s\n", funcString(fn, qual), funcString(m, qual))
+			case valueSet.Lookup(pkg, m.Name()) == nil && pointerSet.Lookup(pkg, m.Name()) != nil && typ == t:
+				fmt.Fprintf(&b, "    %s has a pointer receiver, so it is only in the method set of %s\n", m.Name(), types.TypeString(pt, qual))
+			}
+		}
+		if typ == t && types.Implements(pt, iface) {
+			fmt.Fprintf(&b, "    fix: use a pointer, e.g. var x %s = &%s{}\n", ifaceName, typeName)
+		}
+	}
+	return b.String(), nil
+}
+
+// loadedPackage is a parsed and type-checked package
+type loadedPackage struct {
+	fset  *token.FileSet
+	files []*ast.File
+	pkg   *types.Package
+	info  *types.Info
+}
+
+// loadPackage parses and type-checks the non-test .go files in dir
+// type errors are ignored so a package that does not fully compile can still be explained
+func loadPackage(dir string) (*loadedPackage, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 && f.Name.Name != files[0].Name.Name {
+			continue // a different package in the same directory
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	pkg, _ := conf.Check(files[0].Name.Name, fset, files, info)
+	return &loadedPackage{fset, files, pkg, info}, nil
+}
+
+func lookupInterface(pkg *types.Package, name string) (*types.Interface, error) {
+	var obj types.Object
+	if pkgName, typeName, ok := strings.Cut(name, "."); ok {
+		for _, imp := range pkg.Imports() {
+			if imp.Name() == pkgName {
+				obj = imp.Scope().Lookup(typeName)
+			}
+		}
+	} else {
+		obj = pkg.Scope().Lookup(name)
+		if obj == nil {
+			obj = types.Universe.Lookup(name) // error, comparable, any
+		}
+	}
+	tn, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("no type %s", name)
+	}
+	iface, ok := tn.Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an interface", name)
+	}
+	return iface, nil
+}
+
+func methodList(ms *types.MethodSet, qual types.Qualifier) string {
+	if ms.Len() == 0 {
+		return "    (none)\n"
+	}
+	var b strings.Builder
+	for i := 0; i < ms.Len(); i++ {
+		fmt.Fprintf(&b, "    %s\n", funcString(ms.At(i).Obj().(*types.Func), qual))
+	}
+	return b.String()
+}
+
+// funcString prints "Abs() float64", or "(*AnotherVertex) Scale(f float64)" for a pointer receiver
+func funcString(fn *types.Func, qual types.Qualifier) string {
+	sig := fn.Type().(*types.Signature)
+	s := fn.Name() + strings.TrimPrefix(types.TypeString(withoutRecv(fn), qual), "func")
+	if recv := sig.Recv(); recv != nil {
+		if _, isPtr := recv.Type().(*types.Pointer); isPtr {
+			s = "(" + types.TypeString(recv.Type(), qual) + ") " + s
+		}
+	}
+	return s
+}
+
+// withoutRecv returns the signature of fn without its receiver, so methods of different types can be compared
+func withoutRecv(fn *types.Func) *types.Signature {
+	sig := fn.Type().(*types.Signature)
+	return types.NewSignatureType(nil, nil, nil, sig.Params(), sig.Results(), sig.Variadic())
+}
+
+func explain_test() {
+	for _, pair := range [][2]string{{"AnotherVertex", "Abser"}, {"T", "I"}, {"MyError", "error"}, {"Person", "fmt.Stringer"}} {
+		out, err := Explain(".", pair[0], pair[1])
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println(out)
+	}
+}
+
+// Implicit declaration of interface
+// no need to explicitly declare that it implements the interface
+// calling a method on a nil interface is a run-time error because there is no type inside the interface tuple to indicate which concrete method to call
+// an empty interface may hold values of any type
+// var i interface{} - empty interface -> (<nil>, <nil>)
+// i = 42 -> (42, int)
+// i = "hello" -> ("hello", string)
+
+type I interface {
+	M()
+}
+type T struct {
+	S string
+}
+
+// This method means type T implements the interface I,
+// but we don't need to explicitly declare that it does so.
+// This is called implicit implementation.
+func (t T) M() {
+	fmt.Println(t.S)
+}
+
+func implicit_interface_test() {
+	var i I = T{"hello"}
+	i.M()
+}
+
+func describe(i I) {
+	fmt.Printf("(%v, %T)\n", i, i)
+}
+
+// Handling nil interface values
+// nil interface values behave like nil pointers
+// calling a method on a nil interface is a run-time error because there is no type inside the interface tuple to indicate which concrete method to call
+func (t *T) M2() {
+	// common way to handle nil receivers while in some languages, this would trigger a null pointer exception
+	if t == nil {
+		fmt.Println("<nil>")
+		return
+	}
+	fmt.Println(t.S)
+}
+
+func nil_interface_test() {
+	var i I  // nil interface
+	var t *T // nil pointer
+	i = t
+	describe(i) // (<nil>, *main.T)
+	// M2 is not in I's method set, so get the *T back out first; the assertion works even though the *T is nil
+	i.(*T).M2() // <nil>
+
+	i = &T{"hello"} // non-nil interface
+	describe(i)     // (&{hello}, *main.T)
+	i.(*T).M2()     // hello
+}
+
+// Inspecting values with reflection
+// describe only shows the (value, type) pair of the interface
+// reflect lets a program look inside any value at run time:
+// reflect.ValueOf(x) gives a Value, v.Kind() the underlying kind (Ptr, Struct, Slice, ...),
+// v.Elem() follows pointers and interfaces, v.Field(i) reads struct fields - even unexported ones
+// Inspect walks a value that way and prints a tree
+
+// Inspect returns a tree describing v and everything it refers to
+func Inspect(v any) string {
+	var b strings.Builder
+	if v == nil {
+		// nothing inside the interface: no type, no value
+		b.WriteString("interface (<nil>, <nil>) == nil\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "interface (value, %T) != nil\n", v)
+	ins := &inspector{w: &b, path: make(map[inspectKey]bool), shown: make(map[inspectKey]bool)}
+	ins.walk(reflect.ValueOf(v), 1, "value")
+	return b.String()
+}
+
+// inspectKey identifies a pointer, map or slice, so cycles stop instead of recursing forever
+// slices also need their length: s[:2] and s[:3] share an address but print differently
+type inspectKey struct {
+	addr uintptr
+	len  int
+	typ  reflect.Type
+}
+
+type inspector struct {
+	w     *strings.Builder
+	path  map[inspectKey]bool // keys on the way from the root to the current value
+	shown map[inspectKey]bool // every key printed so far
+}
+
+// seenAs says why key should not be expanded again: "cycle" if it is one of its own ancestors,
+// "already shown" if another branch printed it; "" means print it
+func (ins *inspector) seenAs(key inspectKey) string {
+	switch {
+	case ins.path[key]:
+		return "cycle"
+	case ins.shown[key]:
+		return "already shown"
+	}
+	return ""
+}
+
+// enter records key as printed and on the current path; call the returned func when leaving it
+func (ins *inspector) enter(key inspectKey) func() {
+	ins.path[key] = true
+	ins.shown[key] = true
+	return func() { delete(ins.path, key) }
+}
+
+// maxInspectElems limits how many slice, array and map elements are printed
+const maxInspectElems = 10
+
+func (ins *inspector) line(depth int, label string, format string, args ...any) {
+	fmt.Fprintf(ins.w, "%s%s: %s\n", strings.Repeat("  ", depth), label, fmt.Sprintf(format, args...))
+}
+
+// typeAndKind prints "main.MyFloat (float64)" when the kind adds information, else just the type
+func typeAndKind(t reflect.Type) string {
+	if t.Kind().String() == t.String() {
+		return t.String()
+	}
+	return fmt.Sprintf("%s (%s)", t, t.Kind())
+}
+
+func (ins *inspector) walk(v reflect.Value, depth int, label string) {
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			ins.line(depth, label, "%s = nil pointer", t)
+			return
+		}
+		key := inspectKey{v.Pointer(), 0, t}
+		if why := ins.seenAs(key); why != "" {
+			ins.line(depth, label, "%s -> %#x (%s)", t, v.Pointer(), why)
+			return
+		}
+		defer ins.enter(key)()
+		ins.line(depth, label, "%s -> %#x", t, v.Pointer())
+		ins.walk(v.Elem(), depth+1, "*")
+
+	case reflect.Interface:
+		if v.IsNil() {
+			ins.line(depth, label, "%s = nil interface (<nil>, <nil>)", t)
+			return
+		}
+		ins.line(depth, label, "%s holding (value, %s)", t, v.Elem().Type())
+		ins.walk(v.Elem(), depth+1, "dynamic")
+
+	case reflect.Struct:
+		ins.line(depth, label, "%s", typeAndKind(t))
+		for i := 0; i < v.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Name
+			if !f.IsExported() {
+				name += " (unexported)"
+			}
+			ins.walk(v.Field(i), depth+1, name)
+		}
+
+	case reflect.Slice:
+		if v.IsNil() {
+			ins.line(depth, label, "%s = nil slice len=0 cap=0", typeAndKind(t))
+			return
+		}
+		key := inspectKey{v.Pointer(), v.Len(), t}
+		if why := ins.seenAs(key); why != "" {
+			ins.line(depth, label, "%s len=%d backing array %#x (%s)", typeAndKind(t), v.Len(), v.Pointer(), why)
+			return
+		}
+		defer ins.enter(key)()
+		ins.line(depth, label, "%s len=%d cap=%d backing array %#x", typeAndKind(t), v.Len(), v.Cap(), v.Pointer())
+		ins.elems(v, depth)
+
+	case reflect.Array:
+		ins.line(depth, label, "%s len=%d", typeAndKind(t), v.Len())
+		ins.elems(v, depth)
+
+	case reflect.Map:
+		if v.IsNil() {
+			ins.line(depth, label, "%s = nil map", typeAndKind(t))
+			return
+		}
+		key := inspectKey{v.Pointer(), 0, t}
+		if why := ins.seenAs(key); why != "" {
+			ins.line(depth, label, "%s %#x (%s)", t, v.Pointer(), why)
+			return
+		}
+		defer ins.enter(key)()
+		ins.line(depth, label, "%s len=%d", typeAndKind(t), v.Len())
+		keys := v.MapKeys()
+		// map order is random; sort by printed key so the output is stable
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b)) })
+		for i, k := range keys {
+			if i == maxInspectElems {
+				ins.line(depth+1, "...", "%d more", len(keys)-i)
+				break
+			}
+			ins.walk(v.MapIndex(k), depth+1, fmt.Sprintf("[%v]", k))
+		}
+
+	case reflect.Chan:
+		if v.IsNil() {
+			ins.line(depth, label, "%s = nil channel", t)
+			return
+		}
+		ins.line(depth, label, "%s len=%d cap=%d", t, v.Len(), v.Cap())
+
+	case reflect.Func:
+		if v.IsNil() {
+			ins.line(depth, label, "%s = nil func", t)
+			return
+		}
+		ins.line(depth, label, "%s at %#x", t, v.Pointer())
+
+	case reflect.Invalid:
+		ins.line(depth, label, "invalid")
+
+	default:
+		// basic kinds; printing the reflect.Value works even for unexported fields
+		ins.line(depth, label, "%s = %v", typeAndKind(t), v)
+	}
+}
+
+func (ins *inspector) elems(v reflect.Value, depth int) {
+	for i := 0; i < v.Len(); i++ {
+		if i == maxInspectElems {
+			ins.line(depth+1, "...", "%d more", v.Len()-i)
+			return
+		}
+		ins.walk(v.Index(i), depth+1, fmt.Sprintf("[%d]", i))
+	}
+}
+
+// node is a small linked structure with a cycle, for inspect_test
+type node struct {
+	Name string
+	next *node
+}
+
+func inspect_test() {
+	// the nil_interface_test puzzle, side by side
+	var i I
+	fmt.Print(Inspect(i)) // interface (<nil>, <nil>) == nil
+	var t *T
+	i = t
+	fmt.Print(Inspect(i))
+	// interface (value, *main.T) != nil
+	//   value: *main.T = nil pointer
+
+	i = &T{"hello"}
+	fmt.Print(Inspect(i))
+
+	// a slice of a larger array shares its backing array
+	primes := [6]int{2, 3, 5, 7, 11, 13}
+	fmt.Print(Inspect(primes[1:4]))
+
+	fmt.Print(Inspect(map[string]Abser{"f": MyFloat(-2), "v": &AnotherVertex{3, 4}, "nil": nil}))
+
+	a := &node{Name: "a"}
+	a.next = &node{Name: "b", next: a}
+	fmt.Print(Inspect(a))
+
+	// a slice that contains itself is a cycle too
+	self := []any{nil}
+	self[0] = self
+	fmt.Print(Inspect(self)) // ... dynamic: []interface {} (slice) len=1 backing array 0x... (cycle)
+
+	// the same pointer twice is shared, not a cycle
+	fmt.Print(Inspect([]*node{a, a})) // ... [1]: *main.node -> 0x... (already shown)
+
+	fmt.Print(Inspect(&SafeCounter{v: map[string]int{"k": 1}}))
+}
+
+// Typed nil
+// an interface is nil only when both its type and its value are nil
+// a nil *T stored in an interface gives (nil, *T), which is != nil
+// the classic bug is run() written like run_buggy below:
+// the nil *MyError turns into a non-nil error on the way out
+
+// IsNilish reports whether v is nil, or an interface holding a nil pointer, map, slice, channel, func or interface
+func IsNilish(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// run_buggy is run() written the wrong way; the typednil analyzer reports its return statement
+func run_buggy(fail bool) error {
+	var e *MyError
+	if fail {
+		e = NewMyError("it didn't work")
+	}
+	return e
+}
+
+func typed_nil_test() {
+	var p *T
+	var m map[string]int
+	var i I = p
+	fmt.Println(i == nil, IsNilish(i))         // false true
+	fmt.Println(IsNilish(m), IsNilish(0))      // true false
+	fmt.Println(IsNilish(nil), IsNilish(&T{})) // true false
+
+	err := run_buggy(false)
+	fmt.Println(err != nil, IsNilish(err)) // true true - the bug
+
+	// the static check lives in the typednil module, a go/analysis pass:
+	// cd typednil && go build -o /tmp/typednil ./cmd/typednil && cd .. && /tmp/typednil ./test.go
+	// .../test.go:2201:9: returning variable e of type *MyError as error: when it is nil the result is not == nil; return nil explicitly
+	// it exits with status 3 when it reports something, so it can fail a build
+}
+
+// Type assertions
+// provides access to an interface value's underlying concrete value
+// t := i.(T) -> asserts that the interface value i holds the concrete type T and assigns the underlying T value to the variable t
+// t, ok := i.(T) -> checks whether the interface value i holds the concrete type T
+func type_assertion_test() {
+	var i interface{} = "hello"
+
+	s := i.(string)
+	fmt.Println(s) // hello
+
+	s, ok := i.(string)
+	fmt.Println(s, ok) // hello true
+
+	// f := i.(float64) // panic
+	// fmt.Println(f) // panic: interface conversion: interface {} is string, not float64
+
+	f, ok := i.(float64)
+	fmt.Println(f, ok) // 0 false
+}
+
+// Type switches
+// type switch is a construct that permits several type assertions in series
+// a type switch is like a regular switch statement, but the cases in a type switch specify types (not values), and those values are compared against the type of the value held by the given interface value
+func do(i interface{}) {
+	switch v := i.(type) {
+	case int:
+		fmt.Println("Twice", v*2)
+	case string:
+		fmt.Println(v, "is string")
+	default:
+		fmt.Printf("I don't know about type %T!\n", v)
+	}
+}
+
+func type_switch_test() {
+	do(21)      // Twice 42
+	do("hello") // hello is string
+	do(true)    // I don't know about type bool!
+}
+
+// type dispatch registry
+// a type switch is fixed when the code is compiled; a registry lets handlers be added at run time
+// handlers are looked up by the dynamic type of the value (the T in the (value, T) pair):
+// 1. a handler registered for exactly that type
+// 2. otherwise, a handler for an interface the type implements; if several match, the most specific wins
+//    (interface A is more specific than B when every A also is a B, e.g. error-with-Unwrap vs error)
+// 3. otherwise, the fallback chain, tried in order
+// Register[any] is the catch-all interface, the default case of the switch
+
+// ErrNoHandler is returned by Dispatch when nothing accepts the value
+var ErrNoHandler = errors.New("no handler registered")
+
+type dispatchHandler struct {
+	typ reflect.Type
+	fn  func(any)
+}
+
+// Dispatcher routes values to handlers by their dynamic type; safe for concurrent use
+type Dispatcher struct {
+	mu        sync.RWMutex
+	exact     map[reflect.Type]dispatchHandler
+	ifaces    []dispatchHandler
+	fallbacks []func(any) bool
+	cache     map[reflect.Type]dispatchHandler // resolved interface matches
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{exact: make(map[reflect.Type]dispatchHandler), cache: make(map[reflect.Type]dispatchHandler)}
+}
+
+// Register adds a handler for values of type T; T may be a concrete type or an interface
+// registering the same T again replaces the handler
+func Register[T any](d *Dispatcher, fn func(T)) {
+	typ := reflect.TypeFor[T]()
+	h := dispatchHandler{typ, func(v any) { fn(v.(T)) }}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.cache)
+	if typ.Kind() != reflect.Interface {
+		d.exact[typ] = h
+		return
+	}
+	for i, old := range d.ifaces {
+		if old.typ == typ {
+			d.ifaces[i] = h
+			return
+		}
+	}
+	d.ifaces = append(d.ifaces, h)
+}
+
+// RegisterFallback adds fn to the end of the fallback chain
+// fn returns true if it handled the value; otherwise the next fallback is tried
+func (d *Dispatcher) RegisterFallback(fn func(any) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fallbacks = append(d.fallbacks, fn)
+}
+
+// resolve finds the handler for typ; d.mu must be held
+func (d *Dispatcher) resolve(typ reflect.Type) (dispatchHandler, bool, error) {
+	if h, ok := d.exact[typ]; ok {
+		return h, true, nil
+	}
+	if h, ok := d.cache[typ]; ok {
+		return h, true, nil
+	}
+	var matches []dispatchHandler
+	for _, h := range d.ifaces {
+		if typ.Implements(h.typ) {
+			matches = append(matches, h)
+		}
+	}
+	// keep only the matches no other match is more specific than
+	var best []dispatchHandler
+	for _, h := range matches {
+		dominated := false
+		for _, other := range matches {
+			if other.typ != h.typ && other.typ.Implements(h.typ) && !h.typ.Implements(other.typ) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			best = append(best, h)
+		}
+	}
+	switch len(best) {
+	case 0:
+		return dispatchHandler{}, false, nil
+	case 1:
+		return best[0], true, nil
+	}
+	names := make([]string, len(best))
+	for i, h := range best {
+		names[i] = h.typ.String()
+	}
+	return dispatchHandler{}, false, fmt.Errorf("ambiguous handlers for %v: %s", typ, strings.Join(names, ", "))
+}
+
+// Dispatch calls the handler for v's dynamic type
+func (d *Dispatcher) Dispatch(v any) error {
+	d.mu.Lock()
+	var h dispatchHandler
+	var ok bool
+	var err error
+	if v != nil {
+		typ := reflect.TypeOf(v)
+		h, ok, err = d.resolve(typ)
+		if ok {
+			d.cache[typ] = h
+		}
+	}
+	fallbacks := d.fallbacks
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if ok {
+		h.fn(v)
+		return nil
+	}
+	for _, fb := range fallbacks {
+		if fb(v) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w for %T", ErrNoHandler, v)
+}
+
+// Resolve reports which registered type would handle v, without calling it
+func (d *Dispatcher) Resolve(v any) (reflect.Type, error) {
+	if v == nil {
+		return nil, ErrNoHandler
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	h, ok, err := d.resolve(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoHandler
+	}
+	return h.typ, nil
+}
+
+// Registered lists the registered types: concrete types first, then interfaces, each sorted by name
+func (d *Dispatcher) Registered() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var concrete, ifaces []string
+	for typ := range d.exact {
+		concrete = append(concrete, typ.String())
+	}
+	for _, h := range d.ifaces {
+		ifaces = append(ifaces, h.typ.String()+" (interface)")
+	}
+	slices.Sort(concrete)
+	slices.Sort(ifaces)
+	return append(concrete, ifaces...)
+}
+
+func dispatcher_test() {
+	d := NewDispatcher()
+	// the cases of do(i interface{})
+	Register(d, func(v int) { fmt.Println("Twice", v*2) })
+	Register(d, func(v string) { fmt.Println(v, "is string") })
+	Register(d, func(v any) { fmt.Printf("I don't know about type %T!\n", v) })
+
+	d.Dispatch(21)      // Twice 42
+	d.Dispatch("hello") // hello is string
+	d.Dispatch(true)    // I don't know about type bool!
+
+	// interfaces: Person is a fmt.Stringer; *MyError is an error
+	Register(d, func(s fmt.Stringer) { fmt.Println("stringer:", s) })
+	Register(d, func(e error) { fmt.Println("error:", e) })
+	d.Dispatch(Person{"Arthur Dent", 42})               // stringer: Arthur Dent (42 years)
+	d.Dispatch(ErrNegativeSqrt(-2))                     // error: cannot sqrt negative number: -2
+	d.Dispatch(fmt.Errorf("wrapped: %w", ErrNoHandler)) // error: wrapped: no handler registered
+
+	// a more specific interface beats a more general one
+	Register(d, func(e interface {
+		error
+		Unwrap() error
+	}) {
+		fmt.Println("wrapping error:", e, "->", errors.Unwrap(e))
+	})
+	d.Dispatch(fmt.Errorf("wrapped: %w", ErrNoHandler)) // wrapping error: wrapped: no handler registered -> no handler registered
+
+	// the exact type still beats every interface
+	Register(d, func(p Person) { fmt.Println("person:", p.Name) })
+	d.Dispatch(Person{"Zaphod Beeblebrox", 9001}) // person: Zaphod Beeblebrox
+
+	// fallbacks handle what no type matches; here only nil reaches them
+	d.RegisterFallback(func(v any) bool {
+		if v == nil {
+			fmt.Println("nil value")
+			return true
+		}
+		return false
+	})
+	d.Dispatch(nil) // nil value
+
+	typ, _ := d.Resolve(ErrNegativeSqrt(-1))
+	fmt.Println(typ)            // error
+	fmt.Println(d.Registered()) // [int main.Person string ... (interface) ...]
+}
+
+// Stringers -> same concept of __str__ in python
+// fmt package looks for a String method to convert the value to a string
+//
+//	type Stringer interface {
+//		String() string
+//	}
+type Person struct {
+	Name string `json:"name" csv:"name"`
+	Age  int    `json:"age" csv:"age"`
+}
+
+func (p Person) String() string {
+	return fmt.Sprintf("%v (%v years)", p.Name, p.Age)
+}
+
+func stringer_test() {
+	a := Person{"Arthur Dent", 42}
+	z := Person{"Zaphod Beeblebrox", 9001}
+	fmt.Println(a, z) // Arthur Dent (42 years) Zaphod Beeblebrox (9001 years)
+}
+
+// Formatters
+// String() covers %v and %s; fmt.Formatter takes over every verb
+//
+//	type Formatter interface {
+//		Format(f fmt.State, verb rune)
+//	}
+//
+// f.Flag('+'), f.Width() and f.Precision() tell Format how the verb was written, e.g. %-20.5s
+// fmt.FormatString(f, verb) rebuilds the directive so the work can be handed back to fmt
+// GoString() is what %#v prints (fmt.GoStringer)
+
+// Format implements fmt.Formatter
+//
+//	%v, %s   Arthur Dent (42 years)      width and precision apply, e.g. %-25s, %.6s
+//	%+v      {Name:Arthur Dent Age:42}
+//	%#v      main.Person{Name:"Arthur Dent", Age:42}
+//	%q       "Arthur Dent (42 years)"
+//	%j       {"name":"Arthur Dent","age":42}
+func (p Person) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		io.WriteString(f, p.GoString())
+	case verb == 'v' && f.Flag('+'):
+		fmt.Fprintf(f, "{Name:%s Age:%d}", p.Name, p.Age)
+	case verb == 'v' || verb == 's' || verb == 'q':
+		if verb == 'v' {
+			verb = 's'
+		}
+		fmt.Fprintf(f, fmt.FormatString(f, verb), p.String())
+	case verb == 'j':
+		io.WriteString(f, PersonJSON(p))
+	default:
+		// same shape as fmt's own bad-verb message
+		fmt.Fprintf(f, "%%!%c(main.Person=%s)", verb, p.String())
+	}
+}
+
+// GoString implements fmt.GoStringer
+func (p Person) GoString() string {
+	return fmt.Sprintf("main.Person{Name:%q, Age:%d}", p.Name, p.Age)
+}
+
+// PersonJSON returns p as JSON, using the struct tags on Person
+func PersonJSON(p Person) string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Sprintf("%%!j(%v)", err)
+	}
+	return string(b)
+}
+
+func formatter_test() {
+	a := Person{"Arthur Dent", 42}
+	fmt.Printf("%v\n", a)    // Arthur Dent (42 years)
+	fmt.Printf("%+v\n", a)   // {Name:Arthur Dent Age:42}
+	fmt.Printf("%#v\n", a)   // main.Person{Name:"Arthur Dent", Age:42}
+	fmt.Printf("%q\n", a)    // "Arthur Dent (42 years)"
+	fmt.Printf("%j\n", a)    // {"name":"Arthur Dent","age":42}
+	fmt.Printf("%.6s|\n", a) // Arthur|
+	fmt.Printf("%d\n", a)    // %!d(main.Person=Arthur Dent (42 years))
+
+	// width for aligned tables
+	for _, p := range []Person{a, {"Zaphod Beeblebrox", 9001}, {"Ford", 200}} {
+		fmt.Printf("|%-32s|%32v|\n", p, p)
+	}
+	// |Arthur Dent (42 years)          |          Arthur Dent (42 years)|
+	// |Zaphod Beeblebrox (9001 years)  |  Zaphod Beeblebrox (9001 years)|
+	// |Ford (200 years)                |                Ford (200 years)|
+}
+
+// Person store
+// a small in-memory database of Person values, guarded by a mutex like SafeCounter
+// every change is written to a JSON Lines file (one JSON object per line)
+// the file is replaced atomically: write a temp file next to it, then rename over the old one,
+// so a crash leaves either the old file or the new one, never half of each
+
+const (
+	MinPersonAge = 0
+	MaxPersonAge = 150
+)
+
+// ErrPersonNotFound is returned for an unknown ID
+var ErrPersonNotFound = errors.New("person not found")
+
+// ValidationError describes an invalid field
+type ValidationError struct {
+	Field string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Msg)
+}
+
+// NewPerson returns a Person after checking the name is not empty and the age is in range
+func NewPerson(name string, age int) (Person, error) {
+	p := Person{strings.TrimSpace(name), age}
+	return p, p.Validate()
+}
+
+// Validate checks the fields of p
+func (p Person) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return &ValidationError{"name", "must not be empty"}
+	}
+	if p.Age < MinPersonAge || p.Age > MaxPersonAge {
+		return &ValidationError{"age", fmt.Sprintf("%d is outside %d..%d", p.Age, MinPersonAge, MaxPersonAge)}
+	}
+	return nil
+}
+
+// StoredPerson is a Person with the ID the store gave it
+// Person is embedded, so its fields are flattened into the JSON: {"id":1,"name":"Arthur Dent","age":42}
+type StoredPerson struct {
+	ID int `json:"id"`
+	Person
+}
+
+func (r StoredPerson) String() string {
+	return fmt.Sprintf("#%d %s", r.ID, r.Person.String())
+}
+
+// Format overrides the Format promoted from Person so the ID is shown too
+// %v and %s put the ID in front of what Person prints; width and precision apply to the Person part
+// every other verb is Person's, except the ones that show the whole struct
+//
+//	%v, %s   #1 Arthur Dent (42 years)
+//	%+v      {ID:1 Person:{Name:Arthur Dent Age:42}}
+//	%#v      main.StoredPerson{ID:1, Person:main.Person{Name:"Arthur Dent", Age:42}}
+//	%j       {"id":1,"name":"Arthur Dent","age":42}
+//	%q       "Arthur Dent (42 years)"
+func (r StoredPerson) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprintf(f, "main.StoredPerson{ID:%d, Person:%#v}", r.ID, r.Person)
+	case verb == 'v' && f.Flag('+'):
+		fmt.Fprintf(f, "{ID:%d Person:%+v}", r.ID, r.Person)
+	case verb == 'j':
+		b, err := json.Marshal(r)
+		if err != nil {
+			fmt.Fprintf(f, "%%!j(%v)", err)
+			return
+		}
+		f.Write(b)
+	case verb == 'v' || verb == 's':
+		fmt.Fprintf(f, "#%d ", r.ID)
+		r.Person.Format(f, verb)
+	default:
+		r.Person.Format(f, verb)
+	}
+}
+
+// PersonStore holds people by ID; safe for concurrent use
+type PersonStore struct {
+	mu     sync.RWMutex
+	path   string // "" keeps the store in memory only
+	people map[int]Person
+	nextID int
+}
+
+// NewPersonStore returns an empty in-memory store
+func NewPersonStore() *PersonStore {
+	return &PersonStore{people: make(map[int]Person), nextID: 1}
+}
+
+// OpenPersonStore loads the store from the JSON Lines file at path, if it exists,
+// and saves every later change back to it
+func OpenPersonStore(path string) (*PersonStore, error) {
+	s := NewPersonStore()
+	s.path = path
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var r StoredPerson
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if r.ID <= 0 {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, &ValidationError{"id", fmt.Sprintf("%d is not positive", r.ID)})
+		}
+		if _, dup := s.people[r.ID]; dup {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, &ValidationError{"id", fmt.Sprintf("%d is used more than once", r.ID)})
+		}
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		s.people[r.ID] = r.Person
+		s.nextID = max(s.nextID, r.ID+1)
+	}
+	return s, scanner.Err()
+}
+
+// save writes every record to s.path atomically; s.mu must be held
+func (s *PersonStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the rename has happened
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w) // Encode adds the newline
+	for _, r := range s.sorted() {
+		if err := enc.Encode(r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// sorted returns all records by ID; s.mu must be held
+func (s *PersonStore) sorted() []StoredPerson {
+	out := make([]StoredPerson, 0, len(s.people))
+	for id, p := range s.people {
+		out = append(out, StoredPerson{id, p})
+	}
+	slices.SortFunc(out, func(a, b StoredPerson) int { return cmp.Compare(a.ID, b.ID) })
+	return out
+}
+
+// Create validates p, stores it and returns its new ID
+func (s *PersonStore) Create(p Person) (int, error) {
+	if err := p.Validate(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID
+	s.people[id] = p
+	if err := s.save(); err != nil {
+		delete(s.people, id)
+		return 0, err
+	}
+	s.nextID++
+	return id, nil
+}
+
+// Get returns the person with the given ID
+func (s *PersonStore) Get(id int) (Person, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.people[id]
+	if !ok {
+		return Person{}, fmt.Errorf("id %d: %w", id, ErrPersonNotFound)
+	}
+	return p, nil
+}
+
+// Update replaces the person with the given ID
+func (s *PersonStore) Update(id int, p Person) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.people[id]
+	if !ok {
+		return fmt.Errorf("id %d: %w", id, ErrPersonNotFound)
+	}
+	s.people[id] = p
+	if err := s.save(); err != nil {
+		s.people[id] = old
+		return err
+	}
+	return nil
+}
+
+// Delete removes the person with the given ID
+func (s *PersonStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.people[id]
+	if !ok {
+		return fmt.Errorf("id %d: %w", id, ErrPersonNotFound)
+	}
+	delete(s.people, id)
+	if err := s.save(); err != nil {
+		s.people[id] = old
+		return err
+	}
+	return nil
+}
+
+// PersonQuery selects and orders records; zero fields do not filter
+// MaxAge is a pointer so that 0 can be a real limit: nil means no upper limit
+type PersonQuery struct {
+	NamePrefix string
+	MinAge     int
+	MaxAge     *int
+	SortBy     string // "id" (default), "name" or "age"
+	Desc       bool
+}
+
+// Find returns the records matching q
+func (s *PersonStore) Find(q PersonQuery) ([]StoredPerson, error) {
+	var less func(a, b StoredPerson) int
+	switch q.SortBy {
+	case "", "id":
+		less = func(a, b StoredPerson) int { return cmp.Compare(a.ID, b.ID) }
+	case "name":
+		less = func(a, b StoredPerson) int { return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID)) }
+	case "age":
+		less = func(a, b StoredPerson) int { return cmp.Or(cmp.Compare(a.Age, b.Age), cmp.Compare(a.ID, b.ID)) }
+	default:
+		return nil, fmt.Errorf("cannot sort by %q", q.SortBy)
+	}
+	s.mu.RLock()
+	all := s.sorted()
+	s.mu.RUnlock()
+
+	var out []StoredPerson
+	for _, r := range all {
+		if !strings.HasPrefix(r.Name, q.NamePrefix) || r.Age < q.MinAge || (q.MaxAge != nil && r.Age > *q.MaxAge) {
+			continue
+		}
+		out = append(out, r)
+	}
+	slices.SortFunc(out, less)
+	if q.Desc {
+		slices.Reverse(out)
+	}
+	return out, nil
+}
+
+func person_store_test() {
+	dir, err := os.MkdirTemp("", "people")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "people.jsonl")
+
+	s, _ := OpenPersonStore(path)
+	for _, p := range []Person{{"Arthur Dent", 42}, {"Ford Prefect", 200}, {"Ford Prefect", 40}, {"Trillian", 29}, {"Marvin", 37}} {
+		if _, err := s.Create(p); err != nil {
+			fmt.Println(err) // invalid age: 200 is outside 0..150
+		}
+	}
+	_, err = NewPerson("  ", 30)
+	fmt.Println(err) // invalid name: must not be empty
+
+	s.Update(1, Person{"Arthur Dent", 43})
+	s.Delete(2)
+	_, err = s.Get(2)
+	fmt.Println(err, errors.Is(err, ErrPersonNotFound)) // id 2: person not found true
+
+	forty := 40
+	young, _ := s.Find(PersonQuery{MaxAge: &forty, SortBy: "age", Desc: true})
+	fmt.Println(young) // [#4 Marvin (37 years) #3 Trillian (29 years)]
+
+	// reload from the file
+	data, _ := os.ReadFile(path)
+	fmt.Print(string(data))
+	// {"id":1,"name":"Arthur Dent","age":43}
+	// {"id":3,"name":"Trillian","age":29}
+	// {"id":4,"name":"Marvin","age":37}
+	again, err := OpenPersonStore(path)
+	fmt.Println(err)
+	all, _ := again.Find(PersonQuery{NamePrefix: "A"})
+	fmt.Println(all) // [#1 Arthur Dent (43 years)]
+	id, _ := again.Create(Person{"Zaphod Beeblebrox", 150})
+	fmt.Println(id) // 5 - IDs continue after the highest one stored
+}
+
+// CSV, TSV and tables
+// the codec reads the `csv:"..."` struct tags through reflection instead of naming Person's fields,
+// so adding a field to Person only needs a tag - the functions below stay the same
+// reading collects every bad row instead of stopping at the first one
+
+// csvField is one column of a struct type
+type csvField struct {
+	name  string
+	index int
+}
+
+// csvFields lists the exported fields of t with their column names; `csv:"-"` skips a field
+func csvFields(t reflect.Type) []csvField {
+	var fields []csvField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Tag.Get("csv")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, csvField{name, i})
+	}
+	return fields
+}
+
+// RowError is a problem with one row of the input
+type RowError struct {
+	Line   int    // line number in the input, counting the header as 1
+	Column string // empty when the problem is the row as a whole
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %s: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *RowError) Unwrap() error { return e.Err }
+
+// CSVOptions configures ReadCSV
+type CSVOptions struct {
+	Comma   rune              // ',' for CSV (the default), '\t' for TSV
+	Headers map[string]string // maps header text to column name, e.g. "Full Name" -> "name"
+}
+
+// ReadCSV reads rows of T from r; the first line is the header
+// header names are matched to csv tags ignoring case; unknown headers are ignored,
+// but every field of T needs a column, so a missing one is an error rather than a field left at zero
+// rows that fail to parse (or fail T's Validate method, if it has one) are skipped and reported in the RowErrors
+// the error is only set when the input cannot be read at all
+func ReadCSV[T any](r io.Reader, opts CSVOptions) ([]T, []*RowError, error) {
+	cr := csv.NewReader(r)
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	cr.FieldsPerRecord = -1 // short rows become RowErrors, not a fatal error
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("ReadCSV needs a struct type, not %v", typ)
+	}
+	byName := make(map[string]csvField)
+	for _, f := range csvFields(typ) {
+		byName[strings.ToLower(f.name)] = f
+	}
+	// columns[i] is the field for column i of the input, if any
+	columns := make([]*csvField, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		if mapped, ok := opts.Headers[h]; ok {
+			h = mapped
+		}
+		if f, ok := byName[strings.ToLower(h)]; ok {
+			columns[i] = &f
+			delete(byName, strings.ToLower(h))
+		}
+	}
+	if len(byName) > 0 {
+		var missing []string
+		for _, f := range csvFields(typ) {
+			if _, ok := byName[strings.ToLower(f.name)]; ok {
+				missing = append(missing, f.name)
+			}
+		}
+		return nil, nil, fmt.Errorf("header has no column for %s", strings.Join(missing, ", "))
+	}
+
+	var rows []T
+	var rowErrs []*RowError
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				rowErrs = append(rowErrs, &RowError{perr.Line, "", perr.Err})
+				continue
+			}
+			return rows, rowErrs, err
+		}
+		line, _ := cr.FieldPos(0) // quoted fields may span lines, so ask the reader
+		if len(record) != len(header) {
+			rowErrs = append(rowErrs, &RowError{line, "", fmt.Errorf("has %d fields, header has %d", len(record), len(header))})
+			continue
+		}
+		var v T
+		rv := reflect.ValueOf(&v).Elem()
+		ok := true
+		for i, cell := range record {
+			if columns[i] == nil {
+				continue
+			}
+			if err := setField(rv.Field(columns[i].index), strings.TrimSpace(cell)); err != nil {
+				rowErrs = append(rowErrs, &RowError{line, columns[i].name, err})
+				ok = false
+			}
+		}
+		if !ok {
+			continue
+		}
+		if val, isValidator := any(v).(interface{ Validate() error }); isValidator {
+			if err := val.Validate(); err != nil {
+				rowErrs = append(rowErrs, &RowError{line, "", err})
+				continue
+			}
+		}
+		rows = append(rows, v)
+	}
+	return rows, rowErrs, nil
+}
+
+// setField parses s into a string, integer, float or bool field
+func setField(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %v", f.Type())
+	}
+	return nil
+}
+
+// tableCells returns the header and the cells of every row, formatted with %v
+// T is a struct or a pointer to one; a nil pointer gives a row of empty cells
+func tableCells[T any](rows []T) ([]string, [][]string, error) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("cannot write %v as a table, need a struct or a pointer to one", reflect.TypeFor[T]())
+	}
+	fields := csvFields(typ)
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+	cells := make([][]string, len(rows))
+	for r, row := range rows {
+		rv := reflect.ValueOf(row)
+		cells[r] = make([]string, len(fields))
+		if rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				continue
+			}
+			rv = rv.Elem()
+		}
+		for i, f := range fields {
+			cells[r][i] = fmt.Sprint(rv.Field(f.index).Interface())
+		}
+	}
+	return header, cells, nil
+}
+
+// WriteCSV writes rows as CSV (or TSV with comma '\t') with a header line
+func WriteCSV[T any](w io.Writer, rows []T, comma rune) error {
+	header, cells, err := tableCells(rows)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if comma != 0 {
+		cw.Comma = comma
+	}
+	cw.Write(header)
+	cw.WriteAll(cells) // WriteAll flushes
+	return cw.Error()
+}
+
+// WriteTable writes rows as an aligned text table
+// the last column holds each row's String form, e.g. "Arthur Dent (42 years)", or <nil> for a nil pointer
+func WriteTable[T fmt.Stringer](w io.Writer, rows []T) error {
+	header, cells, err := tableCells(rows)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(append(header, "string"), "\t"))
+	for i, row := range cells {
+		str := "<nil>"
+		if rv := reflect.ValueOf(rows[i]); rv.Kind() != reflect.Pointer || !rv.IsNil() {
+			str = rows[i].String()
+		}
+		fmt.Fprintln(tw, strings.Join(append(row, str), "\t"))
+	}
+	return tw.Flush()
+}
+
+// WriteMarkdown writes rows as a Markdown table
+func WriteMarkdown[T any](w io.Writer, rows []T) error {
+	header, cells, err := tableCells(rows)
+	if err != nil {
+		return err
+	}
+	escape := strings.NewReplacer("|", `\|`, "\n", " ")
+	line := func(cols []string) string {
+		out := make([]string, len(cols))
+		for i, c := range cols {
+			out[i] = escape.Replace(c)
+		}
+		return "| " + strings.Join(out, " | ") + " |\n"
+	}
+	sep := make([]string, len(header))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	var b strings.Builder
+	b.WriteString(line(header))
+	b.WriteString(line(sep))
+	for _, row := range cells {
+		b.WriteString(line(row))
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+func csv_test() {
+	input := `Full Name,Age,Email
+Arthur Dent,42,arthur@example.com
+Ford Prefect,forty,ford@example.com
+,30,nobody@example.com
+Trillian,29
+Marvin,37,marvin@example.com
+`
+	people, rowErrs, err := ReadCSV[Person](strings.NewReader(input), CSVOptions{Headers: map[string]string{"Full Name": "name"}})
+	fmt.Println(err) // <nil>
+	for _, e := range rowErrs {
+		fmt.Println(e)
+	}
+	// line 3, column age: strconv.ParseInt: parsing "forty": invalid syntax
+	// line 4: invalid name: must not be empty
+	// line 5: has 2 fields, header has 3
+
+	WriteCSV(os.Stdout, people, ',')
+	// name,age
+	// Arthur Dent,42
+	// Marvin,37
+	WriteCSV(os.Stdout, people, '\t')
+	WriteTable(os.Stdout, people)
+	// name         age  string
+	// Arthur Dent  42   Arthur Dent (42 years)
+	// Marvin       37   Marvin (37 years)
+	WriteMarkdown(os.Stdout, people)
+	// | name | age |
+	// | --- | --- |
+	// | Arthur Dent | 42 |
+	// | Marvin | 37 |
+
+	// a missing column is an error, not a column of zeros
+	_, _, err = ReadCSV[Person](strings.NewReader("name\nArthur Dent\n"), CSVOptions{})
+	fmt.Println(err) // header has no column for age
+	// pointers to structs work too; anything else is an error
+	fmt.Println(WriteCSV(os.Stdout, []*Person{&people[0], nil}, ','))
+	// name,age
+	// Arthur Dent,42
+	// ,
+	// <nil>
+	fmt.Println(WriteCSV(os.Stdout, []int{1, 2}, ',')) // cannot write int as a table, need a struct or a pointer to one
+}
+
+// Sorting
+// two ways to sort a slice of structs:
+// 1. sort.Interface - define Len, Less and Swap on a named slice type (ByAge, ByName), then sort.Sort(ByAge(people))
+// 2. comparators - a func(a, b T) int that returns <0, 0 or >0, passed to slices.SortFunc
+//    comparators are plain values, so they can be built and combined: by age descending, then by name
+// stability: a stable sort keeps equal elements in their original order
+// sort.Sort and slices.SortFunc are not stable; sort.Stable and slices.SortStableFunc are
+// sorting by the last key first with a stable sort gives the same result as one multi-key sort
+
+// ByAge sorts people by age, youngest first
+type ByAge []Person
+
+func (a ByAge) Len() int           { return len(a) }
+func (a ByAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByAge) Less(i, j int) bool { return a[i].Age < a[j].Age }
+
+// ByName sorts people by name
+type ByName []Person
+
+func (a ByName) Len() int           { return len(a) }
+func (a ByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// MultiSorter is the classic sort.Interface way to sort by several keys:
+// Less tries each less function in turn until one of them decides
+type MultiSorter struct {
+	people []Person
+	less   []func(a, b *Person) bool
+}
+
+// OrderedBy returns a sorter that sorts with the less functions in priority order
+// with no less functions every pair ties and Sort leaves the order alone
+func OrderedBy(less ...func(a, b *Person) bool) *MultiSorter {
+	return &MultiSorter{less: less}
+}
+
+// Sort sorts people in place; it is stable, so people who tie on every key keep their order
+func (ms *MultiSorter) Sort(people []Person) {
+	ms.people = people
+	sort.Stable(ms)
+}
+
+func (ms *MultiSorter) Len() int      { return len(ms.people) }
+func (ms *MultiSorter) Swap(i, j int) { ms.people[i], ms.people[j] = ms.people[j], ms.people[i] }
+func (ms *MultiSorter) Less(i, j int) bool {
+	p, q := &ms.people[i], &ms.people[j]
+	for _, less := range ms.less {
+		switch {
+		case less(p, q):
+			return true
+		case less(q, p):
+			return false
+		}
+	}
+	return false
+}
+
+// Comparator orders two values: negative if a comes first, positive if b does, 0 if they tie
+type Comparator[T any] func(a, b T) int
+
+// Ascending compares by a key extracted from each value
+func Ascending[T any, K cmp.Ordered](key func(T) K) Comparator[T] {
+	return func(a, b T) int { return cmp.Compare(key(a), key(b)) }
+}
+
+// Descending compares by a key, largest first
+func Descending[T any, K cmp.Ordered](key func(T) K) Comparator[T] {
+	return Ascending(key).Reverse()
+}
+
+// Reverse flips the order
+func (c Comparator[T]) Reverse() Comparator[T] {
+	return func(a, b T) int { return c(b, a) }
+}
+
+// Then breaks ties with next
+func (c Comparator[T]) Then(next Comparator[T]) Comparator[T] {
+	return func(a, b T) int {
+		if r := c(a, b); r != 0 {
+			return r
+		}
+		return next(a, b)
+	}
+}
+
+// SortBy sorts s stably by the comparators in priority order
+func SortBy[T any](s []T, cs ...Comparator[T]) {
+	if len(cs) == 0 {
+		return
+	}
+	c := cs[0]
+	for _, next := range cs[1:] {
+		c = c.Then(next)
+	}
+	slices.SortStableFunc(s, c)
+}
+
+// ParseSortSpec builds a comparator from a spec like "-age,name" for any struct type
+// names are the csv tags (or field names) used by ReadCSV; a leading '-' means descending
+func ParseSortSpec[T any](spec string) (Comparator[T], error) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot sort %v by fields", typ)
+	}
+	fields := make(map[string]csvField)
+	for _, f := range csvFields(typ) {
+		fields[strings.ToLower(f.name)] = f
+	}
+	var c Comparator[T]
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		part = strings.TrimPrefix(part, "-")
+		f, ok := fields[strings.ToLower(part)]
+		if !ok {
+			return nil, fmt.Errorf("%v has no field %q", typ, part)
+		}
+		next, err := fieldComparator[T](typ.Field(f.index))
+		if err != nil {
+			return nil, err
+		}
+		if desc {
+			next = next.Reverse()
+		}
+		if c == nil {
+			c = next
+		} else {
+			c = c.Then(next)
+		}
+	}
+	return c, nil
+}
+
+// fieldComparator compares one struct field of a string, integer or float kind
+func fieldComparator[T any](f reflect.StructField) (Comparator[T], error) {
+	get := func(v T) reflect.Value { return reflect.ValueOf(v).FieldByIndex(f.Index) }
+	switch f.Type.Kind() {
+	case reflect.String:
+		return func(a, b T) int { return cmp.Compare(get(a).String(), get(b).String()) }, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b T) int { return cmp.Compare(get(a).Int(), get(b).Int()) }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(a, b T) int { return cmp.Compare(get(a).Uint(), get(b).Uint()) }, nil
+	case reflect.Float32, reflect.Float64:
+		return func(a, b T) int { return cmp.Compare(get(a).Float(), get(b).Float()) }, nil
+	}
+	return nil, fmt.Errorf("cannot sort by field %s of type %v", f.Name, f.Type)
+}
+
+func sort_test() {
+	people := []Person{
+		{"Trillian", 29}, {"Arthur Dent", 42}, {"Marvin", 42}, {"Ford Prefect", 40}, {"Agrajag", 42},
+	}
+
+	// 1. sort.Interface
+	byAge := slices.Clone(people)
+	sort.Stable(ByAge(byAge))
+	fmt.Println(byAge) // [Trillian (29 years) Ford Prefect (40 years) Arthur Dent (42 years) Marvin (42 years) Agrajag (42 years)]
+
+	// age descending, then name: the sort.Interface way
+	age := func(a, b *Person) bool { return a.Age > b.Age }
+	name := func(a, b *Person) bool { return a.Name < b.Name }
+	classic := slices.Clone(people)
+	OrderedBy(age, name).Sort(classic)
+	fmt.Println(classic) // [Agrajag (42 years) Arthur Dent (42 years) Marvin (42 years) Ford Prefect (40 years) Trillian (29 years)]
+
+	// ... the same with stable sorts, last key first
+	twoPass := slices.Clone(people)
+	sort.Stable(ByName(twoPass))
+	sort.Stable(sort.Reverse(ByAge(twoPass)))
+	fmt.Println(slices.Equal(classic, twoPass)) // true
+
+	// 2. comparators
+	generic := slices.Clone(people)
+	SortBy(generic,
+		Descending(func(p Person) int { return p.Age }),
+		Ascending(func(p Person) string { return p.Name }),
+	)
+	fmt.Println(slices.Equal(classic, generic)) // true
+
+	// 3. declaratively, from the struct tags
+	bySpec, _ := ParseSortSpec[Person]("-age,name")
+	fromSpec := slices.Clone(people)
+	slices.SortStableFunc(fromSpec, bySpec)
+	fmt.Println(slices.Equal(classic, fromSpec)) // true
+
+	// comparators work for any struct, e.g. the Vertex from the structs lesson
+	vs := []Vertex{{3, 1}, {1, 2}, {3, 0}}
+	SortBy(vs, Ascending(func(v Vertex) int { return v.X }), Descending(func(v Vertex) int { return v.Y }))
+	fmt.Println(vs) // [{1 2} {3 1} {3 0}]
+
+	_, err := ParseSortSpec[Person]("height")
+	fmt.Println(err) // main.Person has no field "height"
+}
+
+// Stringer exercise: IPAddr
+// type IPAddr [4]byte prints as [127 0 0 1] by default; a String method makes it 127.0.0.1
+// the same idea, grown into small IPv4/IPv6 address and CIDR types
+// net/netip in the standard library is the production version; the conversions below connect to it
+
+type IPAddr [4]byte
+
+func (ip IPAddr) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", ip[0], ip[1], ip[2], ip[3])
+}
+
+// IPv6 is a 128-bit address
+type IPv6 [16]byte
+
+// String uses the canonical form of RFC 5952: lower case, leading zeros dropped,
+// the longest run of two or more zero groups replaced by "::", and IPv4-mapped addresses as ::ffff:1.2.3.4
+func (ip IPv6) String() string {
+	if v4, ok := ip.Unmap(); ok {
+		return "::ffff:" + v4.String()
+	}
+	var groups [8]uint16
+	for i := range groups {
+		groups[i] = uint16(ip[2*i])<<8 | uint16(ip[2*i+1])
+	}
+	// longest run of zero groups; the first one wins a tie
+	bestStart, bestLen := -1, 1
+	for i := 0; i < 8; {
+		if groups[i] != 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < 8 && groups[j] == 0 {
+			j++
+		}
+		if j-i > bestLen {
+			bestStart, bestLen = i, j-i
+		}
+		i = j
+	}
+	var b strings.Builder
+	for i := 0; i < 8; i++ {
+		if i == bestStart {
+			b.WriteString("::")
+			i += bestLen - 1
+			continue
+		}
+		if i > 0 && i != bestStart+bestLen {
+			b.WriteByte(':')
+		}
+		b.WriteString(strconv.FormatUint(uint64(groups[i]), 16))
+	}
+	return b.String()
+}
+
+// Unmap returns the IPv4 address inside an IPv4-mapped IPv6 address (::ffff:a.b.c.d)
+func (ip IPv6) Unmap() (IPAddr, bool) {
+	for _, b := range ip[:10] {
+		if b != 0 {
+			return IPAddr{}, false
+		}
+	}
+	if ip[10] != 0xff || ip[11] != 0xff {
+		return IPAddr{}, false
+	}
+	return IPAddr(ip[12:]), true
+}
+
+// To6 returns ip as an IPv4-mapped IPv6 address
+func (ip IPAddr) To6() IPv6 {
+	var v6 IPv6
+	v6[10], v6[11] = 0xff, 0xff
+	copy(v6[12:], ip[:])
+	return v6
+}
+
+// AddrParseError is returned for text that is not a valid address or prefix
+type AddrParseError struct {
+	Input string
+	Msg   string
+}
+
+func (e *AddrParseError) Error() string {
+	return fmt.Sprintf("cannot parse %q: %s", e.Input, e.Msg)
+}
+
+// ParseIPv4 parses dotted decimal like 192.168.0.1
+func ParseIPv4(s string) (IPAddr, error) {
+	var ip IPAddr
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return IPAddr{}, &AddrParseError{s, fmt.Sprintf("want 4 parts, got %d", len(parts))}
+	}
+	for i, part := range parts {
+		if part == "" || len(part) > 3 || (len(part) > 1 && part[0] == '0') {
+			return IPAddr{}, &AddrParseError{s, fmt.Sprintf("bad part %q", part)}
+		}
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return IPAddr{}, &AddrParseError{s, fmt.Sprintf("bad part %q", part)}
+		}
+		ip[i] = byte(n)
+	}
+	return ip, nil
+}
+
+// ParseIPv6 parses colon-hex like 2001:db8::1, including an IPv4 tail like ::ffff:1.2.3.4
+func ParseIPv6(s string) (IPv6, error) {
+	var ip IPv6
+	if strings.Contains(s, "%") {
+		return ip, &AddrParseError{s, "zones are not supported"}
+	}
+	head, tail, compressed := strings.Cut(s, "::")
+	if compressed && strings.Contains(tail, "::") {
+		return ip, &AddrParseError{s, "more than one ::"}
+	}
+	parse := func(text string, allowV4 bool) ([]byte, error) {
+		if text == "" {
+			return nil, nil
+		}
+		var out []byte
+		groups := strings.Split(text, ":")
+		for i, g := range groups {
+			if allowV4 && i == len(groups)-1 && strings.Contains(g, ".") {
+				v4, err := ParseIPv4(g)
+				if err != nil {
+					return nil, &AddrParseError{s, "bad IPv4 tail: " + err.(*AddrParseError).Msg}
+				}
+				out = append(out, v4[:]...)
+				continue
+			}
+			if g == "" || len(g) > 4 {
+				return nil, &AddrParseError{s, fmt.Sprintf("bad group %q", g)}
+			}
+			n, err := strconv.ParseUint(g, 16, 16)
+			if err != nil {
+				return nil, &AddrParseError{s, fmt.Sprintf("bad group %q", g)}
+			}
+			out = append(out, byte(n>>8), byte(n))
+		}
+		return out, nil
+	}
+	left, err := parse(head, !compressed)
+	if err != nil {
+		return ip, err
+	}
+	right, err := parse(tail, true)
+	if err != nil {
+		return ip, err
+	}
+	switch {
+	case !compressed && len(left) != 16:
+		return ip, &AddrParseError{s, "want 8 groups"}
+	case compressed && len(left)+len(right) > 14:
+		return ip, &AddrParseError{s, ":: must stand for at least one group"}
+	}
+	copy(ip[:], left)
+	copy(ip[16-len(right):], right)
+	return ip, nil
+}
+
+// prefixMatch reports whether the first bits of a and b are equal
+func prefixMatch(a, b []byte, bits int) bool {
+	full, rest := bits/8, bits%8
+	if !bytes.Equal(a[:full], b[:full]) {
+		return false
+	}
+	if rest == 0 {
+		return true
+	}
+	mask := byte(0xff << (8 - rest))
+	return a[full]&mask == b[full]&mask
+}
+
+// parsePrefixBits splits "addr/bits" and checks bits is in 0..max
+func parsePrefixBits(s string, max int) (string, int, error) {
+	addr, bitsText, ok := strings.Cut(s, "/")
+	if !ok {
+		return "", 0, &AddrParseError{s, "missing /bits"}
+	}
+	bits, err := strconv.Atoi(bitsText)
+	if err != nil || bits < 0 || bits > max || (len(bitsText) > 1 && bitsText[0] == '0') {
+		return "", 0, &AddrParseError{s, fmt.Sprintf("bad prefix length %q", bitsText)}
+	}
+	return addr, bits, nil
+}
+
+// Prefix4 is an IPv4 CIDR block like 10.0.0.0/8
+type Prefix4 struct {
+	Addr IPAddr
+	Bits int
+}
+
+func ParsePrefix4(s string) (Prefix4, error) {
+	addr, bits, err := parsePrefixBits(s, 32)
+	if err != nil {
+		return Prefix4{}, err
+	}
+	ip, err := ParseIPv4(addr)
+	return Prefix4{ip, bits}, err
+}
+
+func (p Prefix4) String() string { return fmt.Sprintf("%v/%d", p.Addr, p.Bits) }
+
+// Contains reports whether ip is inside the block
+func (p Prefix4) Contains(ip IPAddr) bool { return prefixMatch(p.Addr[:], ip[:], p.Bits) }
+
+// Prefix6 is an IPv6 CIDR block like 2001:db8::/32
+type Prefix6 struct {
+	Addr IPv6
+	Bits int
+}
+
+func ParsePrefix6(s string) (Prefix6, error) {
+	addr, bits, err := parsePrefixBits(s, 128)
+	if err != nil {
+		return Prefix6{}, err
+	}
+	ip, err := ParseIPv6(addr)
+	return Prefix6{ip, bits}, err
+}
+
+func (p Prefix6) String() string { return fmt.Sprintf("%v/%d", p.Addr, p.Bits) }
+
+// Contains reports whether ip is inside the block
+func (p Prefix6) Contains(ip IPv6) bool { return prefixMatch(p.Addr[:], ip[:], p.Bits) }
+
+// conversions to and from net/netip
+
+func (ip IPAddr) Netip() netip.Addr { return netip.AddrFrom4(ip) }
+func (ip IPv6) Netip() netip.Addr   { return netip.AddrFrom16(ip) }
+func (p Prefix4) Netip() netip.Prefix {
+	return netip.PrefixFrom(p.Addr.Netip(), p.Bits)
+}
+func (p Prefix6) Netip() netip.Prefix {
+	return netip.PrefixFrom(p.Addr.Netip(), p.Bits)
+}
+
+// IPAddrFromNetip converts an IPv4 (or IPv4-mapped IPv6) netip.Addr
+func IPAddrFromNetip(a netip.Addr) (IPAddr, error) {
+	a = a.Unmap()
+	if !a.Is4() {
+		return IPAddr{}, &AddrParseError{a.String(), "not an IPv4 address"}
+	}
+	return IPAddr(a.As4()), nil
+}
+
+// IPv6FromNetip converts any valid netip.Addr; IPv4 addresses become IPv4-mapped
+func IPv6FromNetip(a netip.Addr) (IPv6, error) {
+	if !a.IsValid() {
+		return IPv6{}, &AddrParseError{"", "invalid netip.Addr"}
+	}
+	if a.Zone() != "" {
+		return IPv6{}, &AddrParseError{a.String(), "zones are not supported"}
+	}
+	return IPv6(a.As16()), nil
+}
+
+func ipaddr_test() {
+	hosts := map[string]IPAddr{
+		"loopback":  {127, 0, 0, 1},
+		"googleDNS": {8, 8, 8, 8},
+	}
+	for _, name := range []string{"googleDNS", "loopback"} {
+		fmt.Printf("%v: %v\n", name, hosts[name])
+	}
+	// googleDNS: 8.8.8.8
+	// loopback: 127.0.0.1
+
+	ip, err := ParseIPv4("192.168.1.300")
+	fmt.Println(ip, err) // 0.0.0.0 cannot parse "192.168.1.300": bad part "300"
+
+	for _, s := range []string{"2001:db8:0:0:1:0:0:1", "::1", "::ffff:10.1.2.3", "2001:DB8::"} {
+		v6, err := ParseIPv6(s)
+		// the same text, canonicalised by our String and by net/netip
+		fmt.Println(v6, err, v6.Netip())
+	}
+	// 2001:db8::1:0:0:1 <nil> 2001:db8::1:0:0:1
+	// ::1 <nil> ::1
+	// ::ffff:10.1.2.3 <nil> ::ffff:10.1.2.3
+	// 2001:db8:: <nil> 2001:db8::
+	_, err = ParseIPv6("1::2::3")
+	fmt.Println(err) // cannot parse "1::2::3": more than one ::
+
+	private, _ := ParsePrefix4("10.0.0.0/8")
+	fmt.Println(private.Contains(IPAddr{10, 20, 30, 40}), private.Contains(IPAddr{11, 0, 0, 1})) // true false
+	doc, _ := ParsePrefix6("2001:db8::/32")
+	v6, _ := ParseIPv6("2001:db8:ffff::1")
+	fmt.Println(doc, doc.Contains(v6), doc.Netip().Contains(v6.Netip())) // 2001:db8::/32 true true
+
+	back, _ := IPAddrFromNetip(netip.MustParseAddr("::ffff:8.8.4.4"))
+	fmt.Println(back) // 8.8.4.4
+}
+
+// Errors
+// error type is a built-in interface similar to fmt.Stringer
+//
+//	type error interface {
+//		Error() string
+//	}
+//
+// fmt package looks for a String method to convert the value to a string
+// error handling
+type MyError struct {
+	When time.Time
+	What string
+
+	// filled in by NewMyError
+	File  string // source file and line where the error was created
+	Line  int
+	stack []uintptr // call stack, if stack capture was on
+}
+
+// skipStacks turns stack capture in NewMyError off; the zero value captures
+// it is atomic because any goroutine may create errors while another flips it
+var skipStacks atomic.Bool
+
+// SetCaptureStacks turns stack capture on or off and returns the previous setting
+// runtime.Callers is cheap but not free; services usually leave it on
+func SetCaptureStacks(on bool) (was bool) {
+	return !skipStacks.Swap(!on)
+}
+
+// NewMyError returns a MyError stamped with the time, the caller's file and line, and optionally the stack
+func NewMyError(what string) *MyError {
+	e := &MyError{When: time.Now(), What: what}
+	// skip 1 = NewMyError itself, so this is whoever called it
+	if _, file, line, ok := runtime.Caller(1); ok {
+		e.File, e.Line = file, line
+	}
+	if !skipStacks.Load() {
+		pcs := make([]uintptr, 32)
+		// skip 2 = runtime.Callers and NewMyError
+		e.stack = pcs[:runtime.Callers(2, pcs)]
+	}
+	return e
+}
+
+func (e *MyError) Error() string {
+	return fmt.Sprintf("at %v, %s", e.When, e.What)
+}
+
+// StackTrace returns the captured frames, innermost first; nil if none were captured
+func (e *MyError) StackTrace() []runtime.Frame {
+	if len(e.stack) == 0 {
+		return nil
+	}
+	var out []runtime.Frame
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		f, more := frames.Next()
+		out = append(out, f)
+		if !more {
+			return out
+		}
+	}
+}
+
+// Format prints the message for %v and %s, and the message, location and stack for %+v
+func (e *MyError) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('+'):
+		io.WriteString(f, e.Error())
+		if e.File != "" {
+			fmt.Fprintf(f, "\n  created at %s:%d", e.File, e.Line)
+		}
+		for _, fr := range e.StackTrace() {
+			fmt.Fprintf(f, "\n  %s\n      %s:%d", fr.Function, fr.File, fr.Line)
+		}
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", e.Error())
+	default:
+		io.WriteString(f, e.Error())
+	}
+}
+
+// MarshalJSON writes the error the way services log it, with an RFC 3339 timestamp
+func (e *MyError) MarshalJSON() ([]byte, error) {
+	var stack []string
+	for _, fr := range e.StackTrace() {
+		stack = append(stack, fmt.Sprintf("%s %s:%d", fr.Function, fr.File, fr.Line))
+	}
+	return json.Marshal(struct {
+		When  string   `json:"when"`
+		What  string   `json:"what"`
+		File  string   `json:"file,omitempty"`
+		Line  int      `json:"line,omitempty"`
+		Stack []string `json:"stack,omitempty"`
+	}{e.When.Format(time.RFC3339Nano), e.What, e.File, e.Line, stack})
+}
+
+func run() error {
+	return NewMyError("it didn't work")
+}
+
+func error_test() {
+	if err := run(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func error_stack_test() {
+	err := run()
+	fmt.Printf("%v\n", err)  // at 2024-... , it didn't work
+	fmt.Printf("%+v\n", err) // the same, then "created at .../test.go:NNN" and one frame per caller: main.run, main.error_stack_test, main.main ...
+
+	b, _ := json.Marshal(err)
+	fmt.Println(string(b)) // {"when":"2024-...T...Z","what":"it didn't work","file":".../test.go","line":NNN,"stack":[...]}
+
+	defer SetCaptureStacks(SetCaptureStacks(false))
+	var my *MyError
+	errors.As(run(), &my)
+	fmt.Println(my.Line > 0, my.StackTrace() == nil) // true true - location only
+}
+
+// another error example
+type ErrNegativeSqrt float64
+
+func (e ErrNegativeSqrt) Error() string {
+	return fmt.Sprintf("cannot sqrt negative number: %v", float64(e))
+}
+
+// Sqrt is the Tour exercise: Newton's method with the default options, plus an error for negative input
+// it agrees with math.Sqrt bit for bit (TestNewtonSqrtMatchesMath checks)
+func Sqrt(x float64) (float64, error) {
+	z, _, err := NewtonSqrt(x, NewtonOptions{})
+	return z, err
+}
+
+func error_test2() {
+	fmt.Println(Sqrt(2))  // 1.4142135623730951 <nil>
+	fmt.Println(Sqrt(-2)) // 0 cannot sqrt negative number: -2
+}
+
+// Newton's method
+// start with a guess z and keep improving it using the update
+// z -= (z*z - x) / (2*z), or z -= (z - x/z) / 2, which never squares z
+// each step roughly doubles the number of correct digits
+// stop once the change (delta) is tiny compared to z, or give up after too many steps
+
+// NewtonOptions controls the Newton iteration; zero values pick the defaults
+type NewtonOptions struct {
+	Guess         float64 // initial guess for z; 0 picks 2^(exp/2) where x = frac * 2^exp
+	Epsilon       float64 // stop when |delta| < Epsilon*z; 0 means 1e-10
+	MaxIterations int     // give up after this many steps; 0 means 100
+}
+
+// NewtonStep is one line of the convergence trace
+type NewtonStep struct {
+	Iteration int
+	Z         float64 // z after this step
+	Delta     float64 // change made to z by this step
+}
+
+// ErrNoConvergence is returned when the iteration runs out of steps
+type ErrNoConvergence struct {
+	X          float64
+	Iterations int
+	Last       float64 // best value of z reached
+}
+
+func (e *ErrNoConvergence) Error() string {
+	return fmt.Sprintf("sqrt(%v) did not converge after %d iterations (last z = %v)", e.X, e.Iterations, e.Last)
+}
+
+// NewtonSqrt computes the square root of x with Newton's method
+// it returns the root, the trace of every iteration and an error if x is negative or the iteration does not converge
+func NewtonSqrt(x float64, opts NewtonOptions) (float64, []NewtonStep, error) {
+	if x < 0 {
+		return 0, nil, ErrNegativeSqrt(x)
+	}
+	// 0, +Inf and NaN are their own square roots; Newton would never settle on them
+	if x == 0 || math.IsInf(x, 1) || math.IsNaN(x) {
+		return x, nil, nil
+	}
+
+	eps := opts.Epsilon
+	if eps <= 0 {
+		eps = 1e-10
+	}
+	max := opts.MaxIterations
+	if max <= 0 {
+		max = 100
+	}
+
+	// iterate on m where x = m * 2^(2k) and m is in [0.5, 2), then sqrt(x) = sqrt(m) * 2^k
+	// working near 1 keeps z*z from overflowing for 1e308 or underflowing for subnormals
+	m, exp := math.Frexp(x)
+	if exp%2 != 0 {
+		m *= 2
+		exp--
+	}
+	k := exp / 2
+	z := 1.0
+	if opts.Guess > 0 {
+		z = math.Ldexp(opts.Guess, -k)
+	}
+
+	var trace []NewtonStep
+	for i := 1; i <= max; i++ {
+		delta := (m/z - z) / 2
+		z += delta
+		trace = append(trace, NewtonStep{i, math.Ldexp(z, k), math.Ldexp(delta, k)})
+		if math.Abs(delta) < eps*z {
+			// the last step can be off by one in the final bit; keep whichever neighbour squares closest to m
+			// (FMA gives z*z - m without rounding the product first)
+			for _, c := range []float64{math.Nextafter(z, 0), math.Nextafter(z, 2)} {
+				if math.Abs(math.FMA(c, c, -m)) < math.Abs(math.FMA(z, z, -m)) {
+					z = c
+				}
+			}
+			return math.Ldexp(z, k), trace, nil
+		}
+	}
+	return math.Ldexp(z, k), trace, &ErrNoConvergence{x, max, math.Ldexp(z, k)}
+}
+
+// SqrtReport runs NewtonSqrt and compares every step against math.Sqrt
+func SqrtReport(x float64, opts NewtonOptions) string {
+	z, trace, err := NewtonSqrt(x, opts)
+	want := math.Sqrt(x)
+	var b strings.Builder
+	fmt.Fprintf(&b, "sqrt(%v)\n", x)
+	fmt.Fprintf(&b, "%4s %24s %24s %24s\n", "iter", "z", "delta", "z - math.Sqrt")
+	for _, s := range trace {
+		fmt.Fprintf(&b, "%4d %24.17g %24.17g %24.17g\n", s.Iteration, s.Z, s.Delta, s.Z-want)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "error: %v\n", err)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "newton = %v, math.Sqrt = %v, difference = %v\n", z, want, z-want)
+	return b.String()
+}
+
+func newton_sqrt_test() {
+	fmt.Print(SqrtReport(2, NewtonOptions{}))
+	// the Tour starts from z = 1.0; a bad guess just takes a few more steps
+	fmt.Print(SqrtReport(1e6, NewtonOptions{Guess: 1}))
+	// not enough iterations -> *ErrNoConvergence
+	fmt.Print(SqrtReport(1e6, NewtonOptions{Guess: 1, MaxIterations: 3}))
+	// the ends of the float64 range: no overflow in z*z, no underflow for subnormals
+	z, _, err := NewtonSqrt(math.MaxFloat64, NewtonOptions{})
+	fmt.Println(z, err) // 1.3407807929942596e+154 <nil>
+	z, _, err = NewtonSqrt(5e-324, NewtonOptions{})
+	fmt.Println(z, math.Sqrt(5e-324), err) // 2.2227587494850775e-162 2.2227587494850775e-162 <nil>
+
+	_, _, err = NewtonSqrt(-2, NewtonOptions{})
+	fmt.Println(err) // cannot sqrt negative number: -2
+}
+
+// arbitrary precision with math/big
+// float64 only carries 53 bits (~16 digits) of mantissa
+// big.Float lets you pick the precision (in bits) and the rounding mode
+// big.Rat holds exact fractions like 9/4, so sqrt(9/4) = 3/2 can be returned exactly
+// the same ErrNegativeSqrt is returned so callers only need one error check
+
+// defaultBigPrec is used when neither the caller nor x gives a precision, e.g. for a zero-value big.Float
+const defaultBigPrec = 53
+
+// BigSqrt returns the square root of x rounded to prec bits using mode
+// a prec of 0 keeps the precision of x (53 bits if x has none)
+func BigSqrt(x *big.Float, prec uint, mode big.RoundingMode) (*big.Float, error) {
+	if x.Sign() < 0 {
+		f, _ := x.Float64()
+		return nil, ErrNegativeSqrt(f)
+	}
+	if prec == 0 {
+		prec = x.Prec()
+	}
+	if prec == 0 {
+		prec = defaultBigPrec
+	}
+	if x.IsInf() {
+		return new(big.Float).SetPrec(prec).SetInf(false), nil
+	}
+	// a finite big.Float is an exact fraction m / 2^k, so the rational version rounds it exactly
+	// (big.Float.Sqrt always rounds to nearest even, and rounding its result again can be off in the last bit)
+	r, _ := x.Rat(nil)
+	return sqrtRat(r, prec, mode), nil
+}
+
+// sqrtRat rounds the square root of x >= 0 to prec bits, rounding only once
+// it finds s = floor(sqrt(x * 4^k)) exactly with integers, with k chosen so s has at least prec+2 bits;
+// if the root was not exact, s + 1/2 stands in for it: it lies strictly between the same two
+// rounding boundaries as the true root, so rounding it gives the correctly rounded result in every mode
+func sqrtRat(x *big.Rat, prec uint, mode big.RoundingMode) *big.Float {
+	z := new(big.Float).SetPrec(prec).SetMode(mode)
+	if x.Sign() == 0 {
+		return z
+	}
+	num, den := new(big.Int).Set(x.Num()), new(big.Int).Set(x.Denom())
+	// sqrt(x) is about 2^((len(num) - len(den)) / 2)
+	k := int(prec) + 3 - (num.BitLen()-den.BitLen())/2
+	if k >= 0 {
+		num.Lsh(num, uint(2*k))
+	} else {
+		den.Lsh(den, uint(-2*k))
+	}
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	s := new(big.Int).Sqrt(q)
+	exp := -k
+	if rem.Sign() != 0 || new(big.Int).Mul(s, s).Cmp(q) != 0 {
+		// not exact: (2s + 1) / 2 = s + 1/2
+		s.Lsh(s, 1).Add(s, big.NewInt(1))
+		exp--
+	}
+	exact := new(big.Float).SetPrec(uint(s.BitLen())).SetInt(s)
+	return z.Set(exact.SetMantExp(exact, exp))
+}
+
+// BigAbs is MyFloat.Abs for big.Float values
+func BigAbs(x *big.Float, prec uint, mode big.RoundingMode) *big.Float {
+	if prec == 0 {
+		prec = x.Prec()
+	}
+	return new(big.Float).SetPrec(prec).SetMode(mode).Abs(x)
+}
+
+// RatSqrt returns the exact square root of x
+// ok is false when the numerator or denominator is not a perfect square; use BigRatSqrt then
+func RatSqrt(x *big.Rat) (root *big.Rat, ok bool, err error) {
+	if x.Sign() < 0 {
+		f, _ := x.Float64()
+		return nil, false, ErrNegativeSqrt(f)
+	}
+	num := new(big.Int).Sqrt(x.Num())
+	den := new(big.Int).Sqrt(x.Denom())
+	if new(big.Int).Mul(num, num).Cmp(x.Num()) != 0 || new(big.Int).Mul(den, den).Cmp(x.Denom()) != 0 {
+		return nil, false, nil
+	}
+	return new(big.Rat).SetFrac(num, den), true, nil
+}
+
+// BigRatSqrt rounds the square root of a rational x to prec bits (53 if prec is 0)
+// x is used exactly; converting it to a big.Float first would round it once more
+func BigRatSqrt(x *big.Rat, prec uint, mode big.RoundingMode) (*big.Float, error) {
+	if x.Sign() < 0 {
+		f, _ := x.Float64()
+		return nil, ErrNegativeSqrt(f)
+	}
+	if prec == 0 {
+		prec = defaultBigPrec
+	}
+	return sqrtRat(x, prec, mode), nil
+}
+
+func big_sqrt_test() {
+	two := new(big.Float).SetPrec(200).SetInt64(2)
+	r, _ := BigSqrt(two, 200, big.ToNearestEven)
+	fmt.Println(r.Text('g', 50)) // 1.4142135623730950488016887242096980785696718753769
+
+	// rounding mode matters in the last bit
+	lo, _ := BigSqrt(two, 10, big.ToZero)
+	hi, _ := BigSqrt(two, 10, big.AwayFromZero)
+	fmt.Println(lo.Text('g', 10), hi.Text('g', 10)) // 1.4140625 1.416015625
+
+	fmt.Println(BigAbs(big.NewFloat(-math.Sqrt2), 0, big.ToNearestEven)) // 1.4142135623730951
+
+	// exact rational roots
+	root, ok, _ := RatSqrt(big.NewRat(9, 4))
+	fmt.Println(root, ok) // 3/2 true
+	_, ok, _ = RatSqrt(big.NewRat(2, 1))
+	fmt.Println(ok) // false
+	f, _ := BigRatSqrt(big.NewRat(1, 3), 100, big.ToNearestEven)
+	fmt.Println(f.Text('g', 30)) // 0.577350269189625764509148780502
+
+	// same error as Sqrt
+	_, err := BigSqrt(big.NewFloat(-2), 0, big.ToNearestEven)
+	fmt.Println(err) // cannot sqrt negative number: -2
+	if _, ok := err.(ErrNegativeSqrt); ok {
+		fmt.Println("ErrNegativeSqrt")
+	}
+}
+
+// complex results for negative numbers
+// sqrt(-2) has no real answer, but it does have a complex one: 1.414i
+// math/cmplx works on complex128 values; complex(re, im) builds one, real() and imag() take it apart
+// the principal root is the one with the smallest non-negative angle
+// the n-th roots of any number lie evenly spaced on a circle, 2*pi/n radians apart
+
+// ComplexSqrt returns the principal square root of x; never fails
+func ComplexSqrt(x float64) complex128 {
+	return cmplx.Sqrt(complex(x, 0))
+}
+
+// NthRoot returns the real n-th root of x
+// odd roots of negative numbers are fine (cbrt(-8) = -2); even ones return ErrNegativeSqrt
+func NthRoot(x float64, n int) (float64, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid root degree %d", n)
+	}
+	if x < 0 {
+		if n%2 == 0 {
+			return 0, ErrNegativeSqrt(x)
+		}
+		r, _ := NthRoot(-x, n)
+		return -r, nil
+	}
+	switch {
+	case x == 0 || n == 1 || math.IsInf(x, 1) || math.IsNaN(x):
+		return x, nil
+	case n == 2:
+		return math.Sqrt(x), nil
+	case n == 3:
+		return math.Cbrt(x), nil
+	}
+	// math.Pow is a good guess; one Newton step fixes the last bits (pow(1e30, 1/5) gives 999999.9999999995)
+	// the step is written as (r - x/r^(n-1)) / n so r^n cannot overflow; skip it if r^(n-1) underflows
+	r := math.Pow(x, 1/float64(n))
+	if c := (r - x/math.Pow(r, float64(n-1))) / float64(n); !math.IsInf(c, 0) && !math.IsNaN(c) {
+		r -= c
+	}
+	return r, nil
+}
+
+// ComplexNthRoots returns all n complex n-th roots of z, starting with the principal root
+func ComplexNthRoots(z complex128, n int) []complex128 {
+	if n <= 0 {
+		return nil
+	}
+	r := math.Pow(cmplx.Abs(z), 1/float64(n))
+	theta := cmplx.Phase(z)
+	roots := make([]complex128, n)
+	for k := 0; k < n; k++ {
+		roots[k] = cmplx.Rect(r, (theta+2*math.Pi*float64(k))/float64(n))
+	}
+	return roots
+}
+
+// RootsOfUnity returns the n solutions of z^n = 1
+func RootsOfUnity(n int) []complex128 {
+	return ComplexNthRoots(1, n)
+}
+
+// complex_sqrt_test compares the error result and the complex result
+// pass side_by_side to print Sqrt(-2) both ways on one line
+func complex_sqrt_test(side_by_side bool) {
+	if side_by_side {
+		z, err := Sqrt(-2)
+		fmt.Printf("Sqrt(-2) = %v, %v | ComplexSqrt(-2) = %.4f\n", z, err, ComplexSqrt(-2))
+	} else {
+		fmt.Println(Sqrt(-2))        // 0 cannot sqrt negative number: -2
+		fmt.Println(ComplexSqrt(-2)) // (0+1.4142135623730951i)
+	}
+
+	fmt.Println(NthRoot(-8, 3)) // -2 <nil>
+	fmt.Println(NthRoot(-8, 4)) // 0 cannot sqrt negative number: -8
+
+	for _, w := range RootsOfUnity(4) {
+		fmt.Printf("%.2f ", w) // (1.00+0.00i) (0.00+1.00i) (-1.00+0.00i) (-0.00-1.00i)
+	}
+	fmt.Println()
+}
+
+// wrapping errors
+// an error can wrap another one to add context while keeping the original:
+// fmt.Errorf("loading config: %w", err), or a type with an Unwrap() error method
+// errors.Is(err, target) walks the chain looking for a particular value (a sentinel)
+// errors.As(err, &target) walks the chain looking for a particular type, and fills in target
+// the chain below adds error codes on top, so callers can decide what to do without parsing strings
+
+// ErrorCode classifies an error
+type ErrorCode int
+
+const (
+	CodeUnknown ErrorCode = iota
+	CodeInvalidArgument
+	CodeNotFound
+	CodeUnavailable
+	CodeInternal
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeInvalidArgument:
+		return "invalid argument"
+	case CodeNotFound:
+		return "not found"
+	case CodeUnavailable:
+		return "unavailable"
+	case CodeInternal:
+		return "internal"
+	}
+	return "unknown"
+}
+
+// sentinel errors, one per code: errors.Is(err, ErrInvalidArgument) is true for any error with that code
+var (
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrNotFound        = errors.New("not found")
+	ErrUnavailable     = errors.New("unavailable")
+	ErrInternal        = errors.New("internal error")
+)
+
+var codeSentinels = map[ErrorCode]error{
+	CodeInvalidArgument: ErrInvalidArgument,
+	CodeNotFound:        ErrNotFound,
+	CodeUnavailable:     ErrUnavailable,
+	CodeInternal:        ErrInternal,
+}
+
+// Coder is implemented by errors that know their own code
+type Coder interface {
+	ErrorCode() ErrorCode
+}
+
+// ErrNegativeSqrt and MyError carry a code, so they match the sentinels too
+func (e ErrNegativeSqrt) ErrorCode() ErrorCode { return CodeInvalidArgument }
+func (e ErrNegativeSqrt) Is(target error) bool { return target == ErrInvalidArgument }
+func (e *MyError) ErrorCode() ErrorCode        { return CodeInternal }
+func (e *MyError) Is(target error) bool        { return target == ErrInternal }
+
+// CodedError adds an operation name and a code to the error it wraps
+type CodedError struct {
+	Code ErrorCode
+	Op   string // what was being done, e.g. "load config"
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *CodedError) Unwrap() error        { return e.Err }
+func (e *CodedError) ErrorCode() ErrorCode { return e.Code }
+
+// Is makes errors.Is(err, ErrNotFound) work for a CodedError with CodeNotFound
+func (e *CodedError) Is(target error) bool {
+	s, ok := codeSentinels[e.Code]
+	return ok && s == target
+}
+
+// Wrap adds op and code to err; it returns nil if err is nil, so it can wrap any call's result
+// CodeUnknown keeps the code of err, if it has one
+func Wrap(err error, code ErrorCode, op string) error {
+	if err == nil {
+		return nil
+	}
+	if code == CodeUnknown {
+		code = CodeOf(err)
+	}
+	return &CodedError{code, op, err}
+}
+
+// CodeOf returns the code of the outermost error in the chain that has one
+func CodeOf(err error) ErrorCode {
+	var c Coder
+	if errors.As(err, &c) {
+		return c.ErrorCode()
+	}
+	return CodeUnknown
+}
+
+// a small call chain: main -> handle_request -> load_config -> run
+//                                            -> root_of     -> Sqrt
+
+func load_config() error {
+	return Wrap(run(), CodeUnavailable, "load config")
+}
+
+func root_of(x float64) (float64, error) {
+	r, err := Sqrt(x)
+	if err != nil {
+		return 0, fmt.Errorf("root_of(%v): %w", x, err)
+	}
+	return r, nil
+}
+
+func handle_request(x float64) error {
+	if err := load_config(); err != nil && x == 0 {
+		return Wrap(err, CodeUnknown, "handle request")
+	}
+	_, err := root_of(x)
+	return Wrap(err, CodeUnknown, "handle request")
+}
+
+func wrapping_test() {
+	err := handle_request(-2)
+	fmt.Println(err)                                // handle request: root_of(-2): cannot sqrt negative number: -2
+	fmt.Println(CodeOf(err))                        // invalid argument
+	fmt.Println(errors.Is(err, ErrInvalidArgument)) // true
+	fmt.Println(errors.Is(err, ErrNotFound))        // false
+
+	// errors.As digs the typed error out of the chain, with its value
+	var neg ErrNegativeSqrt
+	if errors.As(err, &neg) {
+		fmt.Println("negative input:", float64(neg)) // negative input: -2
+	}
+
+	err = handle_request(0)
+	fmt.Println(CodeOf(err))                                                 // unavailable - the outer code wins
+	fmt.Println(errors.Is(err, ErrUnavailable), errors.Is(err, ErrInternal)) // true true - both are in the chain
+	var my *MyError
+	if errors.As(err, &my) {
+		fmt.Println("run failed:", my.What) // run failed: it didn't work
+	}
+
+	fmt.Println(Wrap(nil, CodeInternal, "nothing") == nil) // true
+}
+
+// batch operations
+// errors.Join keeps every error instead of stopping at the first one
+// the joined error has Unwrap() []error, so errors.Is and errors.As search all of them
+
+// IndexedError remembers which input of a batch failed
+type IndexedError struct {
+	Index int
+	Err   error
+}
+
+func (e *IndexedError) Error() string { return fmt.Sprintf("input %d: %v", e.Index, e.Err) }
+func (e *IndexedError) Unwrap() error { return e.Err }
+
+// SqrtAll takes the root of every input
+// failed inputs get NaN in the results and an IndexedError in the joined error; err is nil if all succeeded
+func SqrtAll(xs []float64) ([]float64, error) {
+	out := make([]float64, len(xs))
+	var errs []error
+	for i, x := range xs {
+		r, err := Sqrt(x)
+		if err != nil {
+			r = math.NaN()
+			errs = append(errs, &IndexedError{i, err})
+		}
+		out[i] = r
+	}
+	return out, errors.Join(errs...)
+}
+
+// walkErrors visits err and everything it wraps, depth first
+// fn returns false to skip what the current error wraps
+func walkErrors(err error, fn func(error) bool) {
+	if err == nil || !fn(err) {
+		return
+	}
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		walkErrors(u.Unwrap(), fn)
+	case interface{ Unwrap() []error }:
+		for _, e := range u.Unwrap() {
+			walkErrors(e, fn)
+		}
+	}
+}
+
+// Failures lists the IndexedErrors in err, in the order they were joined
+func Failures(err error) []*IndexedError {
+	var out []*IndexedError
+	walkErrors(err, func(e error) bool {
+		if ie, ok := e.(*IndexedError); ok {
+			out = append(out, ie)
+			return false
+		}
+		return true
+	})
+	return out
+}
+
+// AllAs is errors.As for every match: it returns each error of type E in err, not just the first
+func AllAs[E error](err error) []E {
+	var out []E
+	walkErrors(err, func(e error) bool {
+		if t, ok := e.(E); ok {
+			out = append(out, t)
+			return false
+		}
+		return true
+	})
+	return out
+}
+
+// BatchSummary renders the failures of a batch of total inputs, grouped by error code
+//
+//	3 of 5 inputs failed
+//	  invalid argument: 3 (inputs 1, 2, 4)
+func BatchSummary(err error, total int) string {
+	failures := Failures(err)
+	if len(failures) == 0 {
+		return fmt.Sprintf("all %d inputs succeeded", total)
+	}
+	byCode := map[ErrorCode][]int{}
+	var codes []ErrorCode
+	for _, f := range failures {
+		c := CodeOf(f)
+		if _, ok := byCode[c]; !ok {
+			codes = append(codes, c)
+		}
+		byCode[c] = append(byCode[c], f.Index)
+	}
+	slices.Sort(codes)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d inputs failed", len(failures), total)
+	for _, c := range codes {
+		idx := byCode[c]
+		s := make([]string, len(idx))
+		for i, n := range idx {
+			s[i] = strconv.Itoa(n)
+		}
+		fmt.Fprintf(&b, "\n  %v: %d (inputs %s)", c, len(idx), strings.Join(s, ", "))
+	}
+	return b.String()
+}
+
+func batch_test() {
+	xs := []float64{4, -1, -9, 2, -0.5}
+	roots, err := SqrtAll(xs)
+	fmt.Println(roots) // [2 NaN NaN 1.4142135623730951 NaN]
+	fmt.Println(err)
+	// input 1: cannot sqrt negative number: -1
+	// input 2: cannot sqrt negative number: -9
+	// input 4: cannot sqrt negative number: -0.5
+
+	// the joined error still answers errors.Is and errors.As
+	fmt.Println(errors.Is(err, ErrInvalidArgument)) // true
+	var neg ErrNegativeSqrt
+	fmt.Println(errors.As(err, &neg), neg) // true cannot sqrt negative number: -1 - only the first
+
+	for _, f := range Failures(err) {
+		fmt.Println(f.Index, xs[f.Index]) // 1 -1, 2 -9, 4 -0.5
+	}
+	fmt.Println(AllAs[ErrNegativeSqrt](err)) // [cannot sqrt negative number: -1 cannot sqrt negative number: -9 cannot sqrt negative number: -0.5]
+
+	// joins nest - errors from several batches can be joined again and still be found
+	_, err2 := SqrtAll([]float64{-4})
+	all := errors.Join(err, Wrap(err2, CodeUnknown, "second batch"))
+	fmt.Println(len(Failures(all))) // 4
+
+	fmt.Println(BatchSummary(err, len(xs)))
+	// 3 of 5 inputs failed
+	//   invalid argument: 3 (inputs 1, 2, 4)
+
+	_, err = SqrtAll([]float64{1, 4})
+	fmt.Println(err == nil, BatchSummary(err, 2)) // true all 2 inputs succeeded
+}
+
+// how to handle errors in Go
+// 1. return error as a value
+// 2. use panic to abort if error is unrecoverable
+// 3. use log package to log error and continue
+
+// generics
+// functions that take an interface value as an argument must rely on type assertions to access the underlying concrete value
+// template for generics
+// func some_func[T any](arg T) {}
+// functions can be written to work with multiple types using type parameters
+// type parameters are placed in square brackets before the function name
+func Index[T comparable](s []T, x T) int {
+	for i, v := range s {
+		if v == x {
+			return i
+		}
+	}
+	return -1
+}
+
+// comparable - built-in interface
+// type T is comparable if values of type T may be compared using the operators == and !=
+// all basic types are comparable
+// struct type is comparable if all its fields are comparable
+
+func generic_test() {
+	si := []int{10, 20, 15, -10}
+	fmt.Println(Index(si, 15)) // 2
+
+	sf := []float64{10.5, 20.5, 15.5, -10.5}
+	fmt.Println(Index(sf, 15.5)) // 2
+
+	ss := []string{"hello", "world", "golang"}
+	fmt.Println(Index(ss, "golang")) // 2
+}
+
+// more generic slice functions in the style of Index
+// a nil slice behaves like an empty one: len is 0 and range does nothing,
+// so none of these need a special case for nil; results that would be empty are nil too
+// (the standard slices package has many of these; writing them out shows how little they need)
+
+// IndexFunc returns the index of the first element f accepts, or -1
+func IndexFunc[T any](s []T, f func(T) bool) int {
+	for i, v := range s {
+		if f(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// LastIndex returns the index of the last x in s, or -1
+func LastIndex[T comparable](s []T, x T) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == x {
+			return i
+		}
+	}
+	return -1
+}
+
+// IndicesOf returns every index of x in s
+func IndicesOf[T comparable](s []T, x T) []int {
+	var out []int
+	for i, v := range s {
+		if v == x {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Contains reports whether x is in s
+func Contains[T comparable](s []T, x T) bool {
+	return Index(s, x) >= 0
+}
+
+// Filter returns a new slice with the elements keep accepts; s is not modified
+func Filter[T any](s []T, keep func(T) bool) []T {
+	var out []T
+	for _, v := range s {
+		if keep(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Map applies f to every element; T and U can differ, e.g. []Person to []string
+func Map[T, U any](s []T, f func(T) U) []U {
+	if len(s) == 0 {
+		return nil
+	}
+	out := make([]U, len(s))
+	for i, v := range s {
+		out[i] = f(v)
+	}
+	return out
+}
+
+// Reduce folds s into one value, starting from init
+func Reduce[T, A any](s []T, init A, f func(A, T) A) A {
+	acc := init
+	for _, v := range s {
+		acc = f(acc, v)
+	}
+	return acc
+}
+
+// GroupBy puts elements with the same key in the same group, keeping their order
+func GroupBy[T any, K comparable](s []T, key func(T) K) map[K][]T {
+	out := make(map[K][]T)
+	for _, v := range s {
+		k := key(v)
+		out[k] = append(out[k], v)
+	}
+	return out
+}
+
+// Partition splits s into the elements f accepts and the rest
+func Partition[T any](s []T, f func(T) bool) (yes, no []T) {
+	for _, v := range s {
+		if f(v) {
+			yes = append(yes, v)
+		} else {
+			no = append(no, v)
+		}
+	}
+	return yes, no
+}
+
+// Chunk cuts s into pieces of n elements; the last one may be shorter
+// the chunks share s's underlying array, but their capacity is capped so appending to one cannot overwrite the next
+func Chunk[T any](s []T, n int) [][]T {
+	if n < 1 {
+		panic("Chunk: n must be at least 1")
+	}
+	var out [][]T
+	for i := 0; i < len(s); i += n {
+		end := min(i+n, len(s))
+		out = append(out, s[i:end:end])
+	}
+	return out
+}
+
+// Window returns every run of n consecutive elements, e.g. for moving averages
+// there are none if s is shorter than n
+func Window[T any](s []T, n int) [][]T {
+	if n < 1 {
+		panic("Window: n must be at least 1")
+	}
+	var out [][]T
+	for i := 0; i+n <= len(s); i++ {
+		out = append(out, s[i:i+n:i+n])
+	}
+	return out
+}
+
+// Zip pairs up elements by position; extra elements of the longer slice are dropped
+func Zip[X, Y any](xs []X, ys []Y) []Pair[X, Y] {
+	n := min(len(xs), len(ys))
+	if n == 0 {
+		return nil
+	}
+	out := make([]Pair[X, Y], n)
+	for i := range n {
+		out[i] = Pair[X, Y]{xs[i], ys[i]}
+	}
+	return out
+}
+
+// Unique drops repeated elements, keeping the first of each in order
+func Unique[T comparable](s []T) []T {
+	var out []T
+	seen := make(map[T]bool)
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// BinarySearch finds x in a sorted slice in O(log n)
+// it returns where x is, or where it would be inserted, and whether it was found
+func BinarySearch[T cmp.Ordered](s []T, x T) (int, bool) {
+	lo, hi := 0, len(s)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1) // no overflow even for huge slices
+		if cmp.Less(s[mid], x) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(s) && cmp.Compare(s[lo], x) == 0
+}
+
+func generic_slices_test() {
+	nums := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3}
+	even := func(n int) bool { return n%2 == 0 }
+
+	fmt.Println(IndexFunc(nums, even), LastIndex(nums, 5), IndicesOf(nums, 1), Contains(nums, 7)) // 2 8 [1 3] false
+	fmt.Println(Filter(nums, even))                                                               // [4 2 6]
+	fmt.Println(Map(nums, func(n int) string { return strings.Repeat("*", n) })[:3])              // [*** * ****]
+	fmt.Println(Reduce(nums, 0, func(sum, n int) int { return sum + n }))                         // 39
+	fmt.Println(Partition(nums, even))                                                            // [4 2 6] [3 1 1 5 9 5 3]
+	fmt.Println(Chunk(nums, 4))                                                                   // [[3 1 4 1] [5 9 2 6] [5 3]]
+	fmt.Println(Window(nums[:5], 3))                                                              // [[3 1 4] [1 4 1] [4 1 5]]
+	fmt.Println(Unique(nums))                                                                     // [3 1 4 5 9 2 6]
+
+	people := []Person{{"Arthur Dent", 42}, {"Zaphod Beeblebrox", 9001}, {"Ford Prefect", 42}}
+	by_age := GroupBy(people, func(p Person) int { return p.Age })
+	fmt.Println(len(by_age), by_age[42])                   // 2 [Arthur Dent (42 years) Ford Prefect (42 years)]
+	fmt.Println(Zip([]string{"a", "b", "c"}, []int{1, 2})) // [{a 1} {b 2}]
+
+	sorted := slices.Sorted(slices.Values(nums))
+	fmt.Println(BinarySearch(sorted, 5))                            // 6 true
+	fmt.Println(BinarySearch(sorted, 7))                            // 9 false - 7 would go before 9
+	fmt.Println(BinarySearch([]string{"ant", "bee", "cat"}, "bee")) // 1 true
+
+	// nil slices in, nil (or zero) results out - no panics, no special cases
+	var nil_slice []int
+	fmt.Println(IndexFunc(nil_slice, even), LastIndex(nil_slice, 1), Contains(nil_slice, 1))                     // -1 -1 false
+	fmt.Println(IndicesOf(nil_slice, 1) == nil, Filter(nil_slice, even) == nil)                                  // true true
+	fmt.Println(Map(nil_slice, strconv.Itoa) == nil, Reduce(nil_slice, 10, func(a, n int) int { return a + n })) // true 10
+	yes, no := Partition(nil_slice, even)
+	fmt.Println(yes == nil, no == nil, len(GroupBy(nil_slice, even)))                                     // true true 0
+	fmt.Println(Chunk(nil_slice, 2) == nil, Window(nil_slice, 2) == nil)                                  // true true
+	fmt.Println(Zip(nil_slice, nums) == nil, Unique(nil_slice) == nil)                                    // true true
+	fmt.Println(BinarySearch(nil_slice, 1))                                                               // 0 false
+	fmt.Println(Window(nums, 20) == nil, Filter([]int{}, even) == nil, Map([]int{}, strconv.Itoa) == nil) // true true true - empty results are nil as well
+
+	// appending to a chunk does not clobber its neighbour
+	chunks := Chunk(nums, 4)
+	_ = append(chunks[0], 100)
+	fmt.Println(chunks[1][0]) // 5
+}
+
+// decorators
+// a decorator takes a function and returns a new function with the same signature that does a bit more
+// because the signature does not change, decorators can be stacked in any order
+// Fn is the common shape: one argument, a result and an error
+// (a two-argument function like hypot takes a Pair; a function with no error never fails)
+// type parameters A and R keep everything typed, so no reflection is needed
+
+// Fn is a function decorators can wrap
+type Fn[A, R any] func(A) (R, error)
+
+// Decorator wraps an Fn
+type Decorator[A, R any] func(Fn[A, R]) Fn[A, R]
+
+// Pair holds the two arguments of a function like hypot
+type Pair[X, Y any] struct {
+	X X
+	Y Y
+}
+
+// Lift turns a function that cannot fail into an Fn
+func Lift[A, R any](f func(A) R) Fn[A, R] {
+	return func(a A) (R, error) { return f(a), nil }
+}
+
+// Lift2 turns a two-argument function like hypot or math.Pow into an Fn over a Pair
+func Lift2[X, Y, R any](f func(X, Y) R) Fn[Pair[X, Y], R] {
+	return func(p Pair[X, Y]) (R, error) { return f(p.X, p.Y), nil }
+}
+
+// Unlift2 turns an Fn over a Pair back into a plain two-argument function, e.g. for compute
+// errors are dropped and the zero value is returned
+func Unlift2[X, Y, R any](fn Fn[Pair[X, Y], R]) func(X, Y) R {
+	return func(x X, y Y) R {
+		r, _ := fn(Pair[X, Y]{x, y})
+		return r
+	}
+}
+
+// Chain applies decorators so that the first one is the outermost
+func Chain[A, R any](fn Fn[A, R], decorators ...Decorator[A, R]) Fn[A, R] {
+	for i := len(decorators) - 1; i >= 0; i-- {
+		fn = decorators[i](fn)
+	}
+	return fn
+}
+
+// WithTiming reports how long each call took
+func WithTiming[A, R any](report func(time.Duration)) Decorator[A, R] {
+	return func(next Fn[A, R]) Fn[A, R] {
+		return func(a A) (R, error) {
+			start := time.Now()
+			defer func() { report(time.Since(start)) }()
+			return next(a)
+		}
+	}
+}
+
+// WithLogging logs every call's input, output and error; logf is usually log.Printf
+func WithLogging[A, R any](name string, logf func(format string, args ...any)) Decorator[A, R] {
+	return func(next Fn[A, R]) Fn[A, R] {
+		return func(a A) (R, error) {
+			r, err := next(a)
+			if err != nil {
+				logf("%s(%v) failed: %v\n", name, a, err)
+			} else {
+				logf("%s(%v) = %v\n", name, a, r)
+			}
+			return r, err
+		}
+	}
+}
+
+// WithValidation rejects inputs before they reach the function
+func WithValidation[A, R any](check func(A) error) Decorator[A, R] {
+	return func(next Fn[A, R]) Fn[A, R] {
+		return func(a A) (R, error) {
+			if err := check(a); err != nil {
+				var zero R
+				return zero, err
+			}
+			return next(a)
+		}
+	}
+}
+
+// PanicError is returned by WithRecover when the function panicked
+type PanicError struct {
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// WithRecover turns a panic into a *PanicError
+func WithRecover[A, R any]() Decorator[A, R] {
+	return func(next Fn[A, R]) Fn[A, R] {
+		return func(a A) (r R, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					var zero R
+					r, err = zero, &PanicError{v}
+				}
+			}()
+			return next(a)
+		}
+	}
+}
+
+// WithRetry retries failed calls as the policy says; see RetryPolicy below
+// an Fn takes no context, so the retries cannot be cancelled from outside
+func WithRetry[A, R any](p RetryPolicy) Decorator[A, R] {
+	return func(next Fn[A, R]) Fn[A, R] {
+		return func(a A) (R, error) {
+			return RetryValue(context.Background(), p, func(context.Context) (R, error) { return next(a) })
+		}
+	}
+}
+
+// WithRateLimit lets at most one call start per interval; extra calls wait their turn
+func WithRateLimit[A, R any](interval time.Duration) Decorator[A, R] {
+	var mu sync.Mutex
+	var next_slot time.Time
+	return func(next Fn[A, R]) Fn[A, R] {
+		return func(a A) (R, error) {
+			mu.Lock()
+			now := time.Now()
+			wait := next_slot.Sub(now)
+			if wait < 0 {
+				wait = 0
+			}
+			next_slot = now.Add(wait + interval)
+			mu.Unlock()
+			time.Sleep(wait)
+			return next(a)
+		}
+	}
+}
+
+func decorator_test() {
+	// log.Printf has the right signature; fmt.Printf also returns (int, error), so wrap it
+	printf := func(format string, args ...any) { fmt.Printf(format, args...) }
+
+	// hypot, decorated and handed back to compute
+	hypot := func(x, y float64) float64 {
+		return math.Sqrt(x*x + y*y)
+	}
+	logged := Chain(Lift2(hypot),
+		WithLogging[Pair[float64, float64], float64]("hypot", printf),
+	)
+	fmt.Println(compute(Unlift2(logged))) // hypot({3 4}) = 5, then 5
+
+	// Sqrt already has the Fn shape
+	var elapsed time.Duration
+	sqrt := Chain(Sqrt,
+		WithRecover[float64, float64](),
+		WithTiming[float64, float64](func(d time.Duration) { elapsed += d }),
+		WithValidation[float64, float64](func(x float64) error {
+			if math.IsNaN(x) {
+				return errors.New("NaN input")
+			}
+			return nil
+		}),
+		WithLogging[float64, float64]("Sqrt", printf),
+	)
+	sqrt(2)                       // Sqrt(2) = 1.4142135623730951
+	sqrt(-2)                      // Sqrt(-2) failed: cannot sqrt negative number: -2
+	fmt.Println(sqrt(math.NaN())) // 0 NaN input - rejected before logging
+	fmt.Println(elapsed > 0)      // true
+
+	// run() always fails; try it 3 times with a virtual clock so the lesson is instant
+	clock := NewVirtualClock(time.Time{})
+	retried := Chain(
+		func(struct{}) (struct{}, error) { return struct{}{}, run() },
+		WithRetry[struct{}, struct{}](RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     ExponentialBackoff(10*time.Millisecond, 0),
+			Clock:       clock,
+		}),
+	)
+	_, err := retried(struct{}{})
+	fmt.Println(clock.Slept(), err) // [10ms 20ms] gave up after 3 attempts (max attempts): at ..., it didn't work
+
+	// panics become errors
+	boom := Chain(Lift(func(i int) int { return []int{1, 2, 3}[i] }), WithRecover[int, int]())
+	fmt.Println(boom(5)) // 0 panic: runtime error: index out of range [5] with length 3
+
+	// at most one call per 10ms
+	limited := Chain(Lift(func(i int) int { return i }), WithRateLimit[int, int](10*time.Millisecond))
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		limited(i)
+	}
+	fmt.Println(time.Since(start) >= 30*time.Millisecond) // true
+}
+
+// retrying operations like run()
+// RetryPolicy holds the backoff strategy, time limits, error classification and a clock that can be faked;
+// Retry and RetryValue add context cancellation, and WithRetry above turns a policy into a decorator
+
+// Backoff returns the delay before the next try, given the attempt that just failed (from 1) and the previous delay
+type Backoff func(attempt int, prev time.Duration) time.Duration
+
+// ConstantBackoff waits d between every attempt
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int, time.Duration) time.Duration { return d }
+}
+
+// ExponentialBackoff waits base, 2*base, 4*base, ... up to max; max 0 means no cap
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempt int, _ time.Duration) time.Duration {
+		d := base
+		for i := 1; i < attempt && (max == 0 || d < max); i++ {
+			if d > math.MaxInt64/2 {
+				break
+			}
+			d *= 2
+		}
+		if max > 0 && d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// DecorrelatedJitter picks a random delay between base and 3 times the previous one, up to max
+// spreading retries out keeps many clients from hammering a server in lockstep
+// rnd returns a number in [0, 1); nil means rand.Float64
+func DecorrelatedJitter(base, max time.Duration, rnd func() float64) Backoff {
+	if rnd == nil {
+		rnd = rand.Float64
+	}
+	return func(_ int, prev time.Duration) time.Duration {
+		if prev < base {
+			prev = base
+		}
+		d := base + time.Duration(rnd()*float64(3*prev-base))
+		if max > 0 && d > max {
+			d = max
+		}
+		return d
+	}
+}
 
-	s := i.(string)
-	fmt.Println(s) // hello
+// Clock is the part of time that retrying needs
+// Sleep returns early with the context's error if it is cancelled
+type Clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
 
-	s, ok := i.(string)
-	fmt.Println(s, ok) // hello true
+type realClock struct{}
 
-	// f := i.(float64) // panic
-	// fmt.Println(f) // panic: interface conversion: interface {} is string, not float64
+func (realClock) Now() time.Time { return time.Now() }
 
-	f, ok := i.(float64)
-	fmt.Println(f, ok) // 0 false
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// Type switches
-// type switch is a construct that permits several type assertions in series
-// a type switch is like a regular switch statement, but the cases in a type switch specify types (not values), and those values are compared against the type of the value held by the given interface value
-func do(i interface{}) {
-	switch v := i.(type) {
-	case int:
-		fmt.Println("Twice", v*2)
-	case string:
-		fmt.Println(v, "is string")
-	default:
-		fmt.Printf("I don't know about type %T!\n", v)
-	}
+// VirtualClock never really sleeps; Sleep just moves Now forward, so retries with long delays run instantly
+type VirtualClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept []time.Duration
 }
 
-func type_switch_test() {
-	do(21)      // Twice 42
-	do("hello") // hello is string
-	do(true)    // I don't know about type bool!
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
 }
 
-// Stringers -> same concept of __str__ in python
-// fmt package looks for a String method to convert the value to a string
-//
-//	type Stringer interface {
-//		String() string
-//	}
-type Person struct {
-	Name string
-	Age  int
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
 }
 
-func (p Person) String() string {
-	return fmt.Sprintf("%v (%v years)", p.Name, p.Age)
+func (c *VirtualClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.slept = append(c.slept, d)
+	return nil
 }
 
-func stringer_test() {
-	a := Person{"Arthur Dent", 42}
-	z := Person{"Zaphod Beeblebrox", 9001}
-	fmt.Println(a, z) // Arthur Dent (42 years) Zaphod Beeblebrox (9001 years)
+// Advance moves the clock without recording a sleep, e.g. to simulate a slow operation
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
 }
 
-// Errors
-// error type is a built-in interface similar to fmt.Stringer
-//
-//	type error interface {
-//		Error() string
-//	}
-//
-// fmt package looks for a String method to convert the value to a string
-// error handling
-type MyError struct {
-	When time.Time
-	What string
+// Slept returns every delay passed to Sleep so far
+func (c *VirtualClock) Slept() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.slept)
 }
 
-func (e *MyError) Error() string {
-	return fmt.Sprintf("at %v, %s", e.When, e.What)
+// Retryable is implemented by errors that know whether trying again can help
+type Retryable interface {
+	Retryable() bool
 }
 
-func run() error {
-	return &MyError{
-		time.Now(),
-		"it didn't work",
+// PermanentError marks an error that must not be retried
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string   { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error   { return e.Err }
+func (e *PermanentError) Retryable() bool { return false }
+
+// Permanent wraps err so that IsRetryable reports false; nil stays nil
+func Permanent(err error) error {
+	if err == nil {
+		return nil
 	}
+	return &PermanentError{err}
 }
 
-func error_test() {
-	if err := run(); err != nil {
-		fmt.Println(err)
+// IsRetryable is the default classification:
+// the first Retryable in the chain decides, context errors are permanent, anything else is retried
+func IsRetryable(err error) bool {
+	var r Retryable
+	if errors.As(err, &r) {
+		return r.Retryable()
 	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
 }
 
-// another error example
-type ErrNegativeSqrt float64
+// RetryAttempt is what OnAttempt sees after every failed attempt
+type RetryAttempt struct {
+	Attempt  int           // from 1
+	Err      error         // what the attempt returned
+	Elapsed  time.Duration // since the first attempt started
+	Delay    time.Duration // wait before the next attempt; 0 if giving up
+	Retrying bool
+}
 
-func (e ErrNegativeSqrt) Error() string {
-	return fmt.Sprintf("cannot sqrt negative number: %v", float64(e))
+type RetryPolicy struct {
+	MaxAttempts int              // total attempts, including the first
+	MaxElapsed  time.Duration    // give up rather than sleep past this; 0 means no limit
+	Backoff     Backoff          // nil means retry immediately, which needs MaxAttempts to end
+	Retryable   func(error) bool // nil means IsRetryable
+	OnAttempt   func(RetryAttempt)
+	Clock       Clock // nil means the real clock
 }
 
-func Sqrt(x float64) (float64, error) {
-	if x < 0 {
-		return 0, ErrNegativeSqrt(x)
+// withDefaults fills in zero fields; MaxAttempts is 3 unless MaxElapsed and a Backoff bound the loop instead
+// MaxElapsed alone cannot stop immediate retries: a virtual clock never moves and a real one only spins
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 && (p.MaxElapsed <= 0 || p.Backoff == nil) {
+		p.MaxAttempts = 3
+	}
+	if p.Backoff == nil {
+		p.Backoff = ConstantBackoff(0)
 	}
-	return math.Sqrt(x), nil
-	// return 0, nil
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	if p.Clock == nil {
+		p.Clock = realClock{}
+	}
+	return p
 }
 
-func error_test2() {
-	fmt.Println(Sqrt(2))  // 1.4142135623730951 <nil>
-	fmt.Println(Sqrt(-2)) // 0 cannot sqrt negative number: -2
+// RetryError is returned when the policy gives up
+// it unwraps to the last attempt's error and, if the context ended, to the context's error
+type RetryError struct {
+	Attempts int
+	Elapsed  time.Duration
+	Reason   string // "permanent error", "max attempts", "max elapsed time", "zero backoff" or "context done"
+	Err      error
+	Cause    error
 }
 
-// how to handle errors in Go
-// 1. return error as a value
-// 2. use panic to abort if error is unrecoverable
-// 3. use log package to log error and continue
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts (%s): %v", e.Attempts, e.Reason, e.Err)
+}
 
-// generics
-// functions that take an interface value as an argument must rely on type assertions to access the underlying concrete value
-// template for generics
-// func some_func[T any](arg T) {}
-// functions can be written to work with multiple types using type parameters
-// type parameters are placed in square brackets before the function name
-func Index[T comparable](s []T, x T) int {
-	for i, v := range s {
-		if v == x {
-			return i
-		}
+func (e *RetryError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
 	}
-	return -1
+	return []error{e.Err, e.Cause}
 }
 
-// comparable - built-in interface
-// type T is comparable if values of type T may be compared using the operators == and !=
-// all basic types are comparable
-// struct type is comparable if all its fields are comparable
+// Retry calls op until it succeeds or the policy gives up
+func Retry(ctx context.Context, p RetryPolicy, op func(context.Context) error) error {
+	_, err := RetryValue(ctx, p, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, op(ctx)
+	})
+	return err
+}
 
-func generic_test() {
-	si := []int{10, 20, 15, -10}
-	fmt.Println(Index(si, 15)) // 2
+// RetryValue is Retry for operations that return a value
+func RetryValue[T any](ctx context.Context, p RetryPolicy, op func(context.Context) (T, error)) (T, error) {
+	var zero T
+	p = p.withDefaults()
+	start := p.Clock.Now()
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		v, err := op(ctx)
+		if err == nil {
+			return v, nil
+		}
+		elapsed := p.Clock.Now().Sub(start)
+
+		reason := ""
+		switch {
+		case ctx.Err() != nil:
+			reason = "context done"
+		case !p.Retryable(err):
+			reason = "permanent error"
+		case p.MaxAttempts > 0 && attempt >= p.MaxAttempts:
+			reason = "max attempts"
+		default:
+			delay = p.Backoff(attempt, delay)
+			switch {
+			case p.MaxElapsed > 0 && elapsed+delay > p.MaxElapsed:
+				reason = "max elapsed time"
+			case p.MaxAttempts <= 0 && delay <= 0:
+				// only MaxElapsed bounds the loop, and waiting 0 would never get there
+				reason = "zero backoff"
+			}
+		}
 
-	sf := []float64{10.5, 20.5, 15.5, -10.5}
-	fmt.Println(Index(sf, 15.5)) // 2
+		if p.OnAttempt != nil {
+			a := RetryAttempt{Attempt: attempt, Err: err, Elapsed: elapsed, Retrying: reason == ""}
+			if a.Retrying {
+				a.Delay = delay
+			}
+			p.OnAttempt(a)
+		}
+		if reason != "" {
+			return zero, &RetryError{attempt, elapsed, reason, err, ctx.Err()}
+		}
+		if serr := p.Clock.Sleep(ctx, delay); serr != nil {
+			return zero, &RetryError{attempt, p.Clock.Now().Sub(start), "context done", err, serr}
+		}
+	}
+}
 
-	ss := []string{"hello", "world", "golang"}
-	fmt.Println(Index(ss, "golang")) // 2
+func retry_test() {
+	ctx := context.Background()
+	log := func(a RetryAttempt) {
+		fmt.Printf("  attempt %d after %v: %v (retry in %v: %v)\n", a.Attempt, a.Elapsed, a.Err.(*MyError).What, a.Delay, a.Retrying)
+	}
+
+	// run() never succeeds; with a virtual clock the 7s of backoff takes no real time
+	clock := NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	err := Retry(ctx, RetryPolicy{
+		MaxAttempts: 4,
+		Backoff:     ExponentialBackoff(time.Second, 0),
+		OnAttempt:   log,
+		Clock:       clock,
+	}, func(context.Context) error { return run() })
+	//   attempt 1 after 0s: it didn't work (retry in 1s: true)
+	//   attempt 2 after 1s: it didn't work (retry in 2s: true)
+	//   attempt 3 after 3s: it didn't work (retry in 4s: true)
+	//   attempt 4 after 7s: it didn't work (retry in 0s: false)
+	fmt.Println(err)                                                   // gave up after 4 attempts (max attempts): at ..., it didn't work
+	fmt.Println(errors.Is(err, ErrInternal), clock.Slept())            // true [1s 2s 4s]
+	fmt.Println(ExponentialBackoff(time.Second, 5*time.Second)(10, 0)) // 5s - capped
+
+	// stop on time rather than count: the next 8s wait would pass the 10s budget
+	clock = NewVirtualClock(time.Time{})
+	err = Retry(ctx, RetryPolicy{
+		MaxElapsed: 10 * time.Second,
+		Backoff:    ExponentialBackoff(time.Second, 0),
+		Clock:      clock,
+	}, func(context.Context) error { return run() })
+	fmt.Println(err.(*RetryError).Attempts, err.(*RetryError).Reason) // 4 max elapsed time
+
+	// decorrelated jitter, with a fixed "random" source so the lesson is repeatable
+	jitter := DecorrelatedJitter(100*time.Millisecond, time.Second, func() float64 { return 0.5 })
+	var d time.Duration
+	for i := 1; i <= 5; i++ {
+		d = jitter(i, d)
+		fmt.Print(d, " ") // 200ms 350ms 575ms 912.5ms 1s
+	}
+	fmt.Println()
+
+	// a flaky operation that works on the third try
+	calls := 0
+	v, err := RetryValue(ctx, RetryPolicy{Clock: NewVirtualClock(time.Time{})}, func(context.Context) (float64, error) {
+		calls++
+		if calls < 3 {
+			return 0, errors.New("connection reset")
+		}
+		return Sqrt(2)
+	})
+	fmt.Println(v, err, calls) // 1.4142135623730951 <nil> 3
+
+	// classification: invalid input will not get better by retrying
+	// either via a Retryable func...
+	calls = 0
+	_, err = RetryValue(ctx, RetryPolicy{
+		MaxAttempts: 5,
+		Retryable:   func(err error) bool { return !errors.Is(err, ErrInvalidArgument) },
+	}, func(context.Context) (float64, error) {
+		calls++
+		return Sqrt(-2)
+	})
+	fmt.Println(calls, err) // 1 gave up after 1 attempts (permanent error): cannot sqrt negative number: -2
+	// ...or by marking the error itself
+	err = Retry(ctx, RetryPolicy{MaxAttempts: 5}, func(context.Context) error {
+		return Permanent(errors.New("bad credentials"))
+	})
+	fmt.Println(err.(*RetryError).Attempts) // 1
+
+	// cancelling the context stops the waiting too
+	ctx, cancel := context.WithCancel(ctx)
+	err = Retry(ctx, RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     ConstantBackoff(time.Hour),
+		OnAttempt:   func(RetryAttempt) { cancel() },
+	}, func(context.Context) error { return run() })
+	fmt.Println(errors.Is(err, context.Canceled), err.(*RetryError).Attempts) // true 1
 }
 
 // goroutines
@@ -447,6 +5228,357 @@ func goroutine_test() {
 	fmt.Println(x, y, x+y)
 }
 
+// statistics
+// sum only totals ints; the same split-in-half pattern works for any statistic
+// as long as two partial results can be merged into one
+// each goroutine fills its own Stats, sends it on a channel, and the receiver merges them
+
+// Number is any integer or floating-point type
+type Number interface {
+	Integer | ~float32 | ~float64
+}
+
+// ErrNoData is returned when a statistic needs at least one value
+var ErrNoData = errors.New("no data")
+
+// Stats keeps count, sum, mean, variance, min and max in constant memory
+// the zero value is ready to use
+type Stats[T Number] struct {
+	n        int
+	sum, c   float64 // Kahan sum and its running compensation
+	mean, m2 float64 // Welford: running mean and sum of squared differences from it
+	min, max T
+}
+
+// Add adds one value
+func (s *Stats[T]) Add(x T) {
+	v := float64(x)
+	if s.n == 0 || x < s.min {
+		s.min = x
+	}
+	if s.n == 0 || x > s.max {
+		s.max = x
+	}
+	s.n++
+
+	// Kahan summation: c remembers the low-order bits lost by the previous addition
+	y := v - s.c
+	t := s.sum + y
+	s.c = (t - s.sum) - y
+	s.sum = t
+
+	// Welford's update is stable even when the values are large and close together
+	delta := v - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (v - s.mean)
+}
+
+// Merge folds the values seen by o into s, as if they had been added to s directly
+func (s *Stats[T]) Merge(o *Stats[T]) {
+	if o.n == 0 {
+		return
+	}
+	if s.n == 0 {
+		*s = *o
+		return
+	}
+	n := float64(s.n + o.n)
+	delta := o.mean - s.mean
+	s.m2 += o.m2 + delta*delta*float64(s.n)*float64(o.n)/n
+	s.mean += delta * float64(o.n) / n
+	s.n += o.n
+	y := o.sum - o.c - s.c
+	t := s.sum + y
+	s.c = (t - s.sum) - y
+	s.sum = t
+	s.min = min(s.min, o.min)
+	s.max = max(s.max, o.max)
+}
+
+func (s *Stats[T]) Count() int     { return s.n }
+func (s *Stats[T]) Sum() float64   { return s.sum }
+func (s *Stats[T]) Mean() float64  { return s.mean }
+func (s *Stats[T]) Min() (T, bool) { return s.min, s.n > 0 }
+func (s *Stats[T]) Max() (T, bool) { return s.max, s.n > 0 }
+
+// Variance returns the sample variance (divides by n-1); 0 for fewer than two values
+func (s *Stats[T]) Variance() float64 {
+	if s.n < 2 {
+		return 0
+	}
+	return s.m2 / float64(s.n-1)
+}
+
+func (s *Stats[T]) StdDev() float64 { return math.Sqrt(s.Variance()) }
+
+func (s *Stats[T]) String() string {
+	return fmt.Sprintf("n=%d sum=%v mean=%.4g sd=%.4g min=%v max=%v", s.n, s.sum, s.mean, s.StdDev(), s.min, s.max)
+}
+
+// Summarize is the batch form of Stats
+func Summarize[T Number](xs []T) *Stats[T] {
+	s := &Stats[T]{}
+	for _, x := range xs {
+		s.Add(x)
+	}
+	return s
+}
+
+// Percentile returns the p-th percentile (0 to 100) of xs, interpolating between neighbours
+// xs is not modified
+func Percentile[T Number](xs []T, p float64) (float64, error) {
+	if len(xs) == 0 {
+		return 0, ErrNoData
+	}
+	if p < 0 || p > 100 || math.IsNaN(p) {
+		return 0, fmt.Errorf("percentile %v out of range [0, 100]", p)
+	}
+	sorted := slices.Clone(xs)
+	slices.Sort(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(rank)
+	if lo == len(sorted)-1 {
+		return float64(sorted[lo]), nil
+	}
+	frac := rank - float64(lo)
+	return float64(sorted[lo]) + frac*(float64(sorted[lo+1])-float64(sorted[lo])), nil
+}
+
+// Median is the 50th percentile
+func Median[T Number](xs []T) (float64, error) {
+	return Percentile(xs, 50)
+}
+
+// t-digest
+// exact percentiles need every value; a t-digest keeps a few hundred centroids (mean, weight) instead
+// centroids near the middle may grow large, centroids near the tails stay small,
+// so extreme percentiles like p99 stay accurate
+
+type centroid struct {
+	Mean, Weight float64
+}
+
+// TDigest estimates percentiles of a stream in bounded memory
+type TDigest struct {
+	Compression float64 // higher keeps more centroids and is more accurate; 0 means 100
+	centroids   []centroid
+	buffer      []centroid
+	total       float64
+	min, max    float64
+}
+
+// Add adds one value
+func (t *TDigest) Add(x float64) {
+	if t.total == 0 || x < t.min {
+		t.min = x
+	}
+	if t.total == 0 || x > t.max {
+		t.max = x
+	}
+	t.total++
+	t.buffer = append(t.buffer, centroid{x, 1})
+	if len(t.buffer) >= 500 {
+		t.compress()
+	}
+}
+
+// Merge folds o into t
+func (t *TDigest) Merge(o *TDigest) {
+	if o.total == 0 {
+		return
+	}
+	if t.total == 0 || o.min < t.min {
+		t.min = o.min
+	}
+	if t.total == 0 || o.max > t.max {
+		t.max = o.max
+	}
+	t.total += o.total
+	t.buffer = append(t.buffer, o.centroids...)
+	t.buffer = append(t.buffer, o.buffer...)
+	t.compress()
+}
+
+// compress merges the buffer into the centroids
+// neighbouring centroids are combined while the result stays under the size limit for its quantile
+func (t *TDigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	delta := t.Compression
+	if delta <= 0 {
+		delta = 100
+	}
+	all := append(t.centroids, t.buffer...)
+	slices.SortFunc(all, func(a, b centroid) int { return cmp.Compare(a.Mean, b.Mean) })
+	merged := []centroid{all[0]}
+	before := 0.0 // weight of the centroids already finished
+	for _, c := range all[1:] {
+		cur := &merged[len(merged)-1]
+		q := (before + cur.Weight + c.Weight/2) / t.total
+		limit := 4 * t.total * q * (1 - q) / delta
+		if cur.Weight+c.Weight <= math.Max(limit, 1) {
+			cur.Mean += (c.Mean - cur.Mean) * c.Weight / (cur.Weight + c.Weight)
+			cur.Weight += c.Weight
+		} else {
+			before += cur.Weight
+			merged = append(merged, c)
+		}
+	}
+	t.centroids = merged
+	t.buffer = t.buffer[:0]
+}
+
+// Percentile estimates the p-th percentile (0 to 100)
+func (t *TDigest) Percentile(p float64) (float64, error) {
+	if t.total == 0 {
+		return 0, ErrNoData
+	}
+	if p < 0 || p > 100 || math.IsNaN(p) {
+		return 0, fmt.Errorf("percentile %v out of range [0, 100]", p)
+	}
+	t.compress()
+	target := p / 100 * t.total
+	// each centroid sits at the middle of its weight; interpolate between neighbouring middles,
+	// and between min/max and the outermost centroids
+	prevPos, prevMean := 0.0, t.min
+	cum := 0.0
+	for _, c := range t.centroids {
+		pos := cum + c.Weight/2
+		if target < pos {
+			return prevMean + (target-prevPos)/(pos-prevPos)*(c.Mean-prevMean), nil
+		}
+		prevPos, prevMean = pos, c.Mean
+		cum += c.Weight
+	}
+	if t.total == prevPos {
+		return t.max, nil
+	}
+	return prevMean + (target-prevPos)/(t.total-prevPos)*(t.max-prevMean), nil
+}
+
+// Histogram counts values into buckets
+// Counts[i] holds values below Bounds[i] (and at or above Bounds[i-1]); the last count is everything >= the last bound
+type Histogram struct {
+	Bounds []float64
+	Counts []int
+}
+
+// NewHistogram returns a histogram with the given ascending upper bounds
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]int, len(bounds)+1)}
+}
+
+// LinearBuckets returns n bounds start, start+width, ...
+func LinearBuckets(start, width float64, n int) []float64 {
+	b := make([]float64, n)
+	for i := range b {
+		b[i] = start + float64(i)*width
+	}
+	return b
+}
+
+func (h *Histogram) Add(x float64) {
+	i, found := slices.BinarySearch(h.Bounds, x)
+	if found {
+		i++ // a value equal to a bound goes in the bucket above it
+	}
+	h.Counts[i]++
+}
+
+// Merge adds o's counts to h; both must have the same bounds
+func (h *Histogram) Merge(o *Histogram) error {
+	if !slices.Equal(h.Bounds, o.Bounds) {
+		return errors.New("histogram bounds differ")
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	return nil
+}
+
+func (h *Histogram) String() string {
+	var b strings.Builder
+	for i, c := range h.Counts {
+		switch {
+		case len(h.Bounds) == 0:
+			fmt.Fprintf(&b, "     all        %d\n", c)
+		case i == 0:
+			fmt.Fprintf(&b, "       < %-6v %d\n", h.Bounds[0], c)
+		case i == len(h.Bounds):
+			fmt.Fprintf(&b, "      >= %-6v %d\n", h.Bounds[i-1], c)
+		default:
+			fmt.Fprintf(&b, "[%v, %v) %d\n", h.Bounds[i-1], h.Bounds[i], c)
+		}
+	}
+	return b.String()
+}
+
+// stats_worker is sum for every statistic at once
+func stats_worker(s []int, c chan *Stats[int]) {
+	st := &Stats[int]{}
+	for _, v := range s {
+		st.Add(v)
+	}
+	c <- st
+}
+
+func stats_test() {
+	// same split as goroutine_test
+	s := []int{7, 2, 8, -9, 4, 0}
+	c := make(chan *Stats[int])
+	go stats_worker(s[:len(s)/2], c)
+	go stats_worker(s[len(s)/2:], c)
+	x, y := <-c, <-c
+	x.Merge(y)
+	fmt.Println(x)                   // n=6 sum=12 mean=2 sd=6.164 min=-9 max=8
+	fmt.Println(Summarize(s))        // same as the merged result
+	fmt.Println(Median(s))           // 3 <nil>
+	fmt.Println(Percentile(s, 90))   // 7.5 <nil>
+	fmt.Println(Median([]float64{})) // 0 no data
+
+	// Kahan summation keeps the small values that plain += drops
+	plain, k := 0.0, &Stats[float64]{}
+	for _, v := range []float64{1e16, 1, 1, 1, 1, -1e16} {
+		plain += v
+		k.Add(v)
+	}
+	fmt.Println(plain, k.Sum()) // 0 4
+
+	// t-digest and histogram over a larger stream, split across 4 goroutines
+	// each worker fills its own slot and they are merged in worker order,
+	// because a t-digest's estimates depend on the order it merges in
+	const workers = 4
+	digests := make([]*TDigest, workers)
+	hists := make([]*Histogram, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			d, h := &TDigest{}, NewHistogram(LinearBuckets(0, 2500, 4)...)
+			for i := w; i < 10000; i += workers {
+				d.Add(float64(i))
+				h.Add(float64(i))
+			}
+			digests[w], hists[w] = d, h
+		}(w)
+	}
+	wg.Wait()
+	d, h := &TDigest{}, NewHistogram(LinearBuckets(0, 2500, 4)...)
+	for w := 0; w < workers; w++ {
+		d.Merge(digests[w])
+		if err := h.Merge(hists[w]); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	p50, _ := d.Percentile(50)
+	p99, _ := d.Percentile(99)
+	fmt.Printf("p50=%.0f p99=%.0f\n", p50, p99) // p50=4998 p99=9899 (exact: 4999.5 and 9899.01)
+	fmt.Print(h)
+}
+
 // buffered channels
 // ch := make(chan int, 100) // channel can buffer up to 100 values
 
@@ -472,7 +5604,7 @@ func buffered_channel_test() {
 // fibonnacci with channels - range and close
 func fibonacci(n int, c chan int) {
 	x, y := 0, 1
-	for i := 0; i < n; n++ {
+	for i := 0; i < n; i++ {
 		c <- x
 		x, y = y, x+y
 	}
@@ -488,6 +5620,197 @@ func range_and_close_test() {
 	}
 }
 
+// fibonacci, five ways
+// 1. iterator - iter.Seq is a func(yield func(uint64) bool); range calls it and it yields each value
+// 2. closure - like adder, the function keeps the last two values between calls
+// 3. channel - a goroutine sends the values; ctx lets the receiver stop it early
+// 4. big.Int - never overflows, only gets slower
+// 5. fast doubling - jumps straight to F(n) in O(log n) steps using
+//    F(2k) = F(k) * (2*F(k+1) - F(k))
+//    F(2k+1) = F(k)^2 + F(k+1)^2
+// F(93) is the largest Fibonacci number that fits in a uint64
+
+const maxFibUint64 = 93
+
+// FibSeq yields F(0), F(1), ... and stops after F(93), before the values would overflow
+func FibSeq() iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		var x, y uint64 = 0, 1
+		for {
+			if !yield(x) {
+				return
+			}
+			next, err := CheckedAdd(x, y)
+			if err != nil {
+				// y is F(93); F(94) would not fit
+				yield(y)
+				return
+			}
+			x, y = y, next
+		}
+	}
+}
+
+// fibonacci_closure returns a function that returns successive Fibonacci numbers
+// ok turns false once the next value would overflow
+func fibonacci_closure() func() (uint64, bool) {
+	var x, y uint64 = 0, 1
+	last, done := false, false
+	return func() (uint64, bool) {
+		if done {
+			return 0, false
+		}
+		r := x
+		if last {
+			done = true // r is F(93)
+			return r, true
+		}
+		next, err := CheckedAdd(x, y)
+		if err != nil {
+			last = true // y is F(93); return it on the next call, then stop
+		}
+		x, y = y, next
+		return r, true
+	}
+}
+
+// FibChan sends the first n Fibonacci numbers (at most 94) and closes the channel
+// it stops early if ctx is cancelled, so the goroutine never leaks
+func FibChan(ctx context.Context, n int) <-chan uint64 {
+	c := make(chan uint64)
+	go func() {
+		defer close(c)
+		i := 0
+		for v := range FibSeq() {
+			if i >= n {
+				return
+			}
+			select {
+			case c <- v:
+			case <-ctx.Done():
+				return
+			}
+			i++
+		}
+	}()
+	return c
+}
+
+// Fib returns F(n) using fast doubling, or an error if it does not fit in a uint64
+func Fib(n int) (uint64, error) {
+	if n < 0 {
+		return 0, fmt.Errorf("fib(%d): negative index", n)
+	}
+	if n > maxFibUint64 {
+		return 0, fmt.Errorf("fib(%d) overflows uint64 (largest is fib(%d))", n, maxFibUint64)
+	}
+	// a = F(k), b = F(k+1), walking the bits of n from the top
+	// b may wrap around on the last step, but the arithmetic is mod 2^64 and F(n) itself fits, so a is exact
+	var a, b uint64 = 0, 1
+	for bit := bits.Len(uint(n)); bit > 0; bit-- {
+		c := a * (2*b - a) // F(2k)
+		d := a*a + b*b     // F(2k+1)
+		if n>>(bit-1)&1 == 0 {
+			a, b = c, d
+		} else {
+			a, b = d, c+d
+		}
+	}
+	return a, nil
+}
+
+// BigFib returns F(n) for any n >= 0 using fast doubling on big.Int
+func BigFib(n int) (*big.Int, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("fib(%d): negative index", n)
+	}
+	a, b := big.NewInt(0), big.NewInt(1)
+	t := new(big.Int)
+	for bit := bits.Len(uint(n)); bit > 0; bit-- {
+		// c = a * (2b - a)
+		c := new(big.Int).Lsh(b, 1)
+		c.Sub(c, a).Mul(c, a)
+		// d = a^2 + b^2
+		d := new(big.Int).Mul(a, a)
+		d.Add(d, t.Mul(b, b))
+		if n>>(bit-1)&1 == 0 {
+			a, b = c, d
+		} else {
+			a, b = d, c.Add(c, d)
+		}
+	}
+	return a, nil
+}
+
+func fibonacci_test() {
+	// iterator
+	for i, v := range enumerate(FibSeq()) {
+		if i == 10 {
+			break // yield returns false and the iterator stops
+		}
+		fmt.Print(v, " ")
+	}
+	fmt.Println() // 0 1 1 2 3 5 8 13 21 34
+
+	// closure
+	f := fibonacci_closure()
+	for i := 0; i < 10; i++ {
+		v, _ := f()
+		fmt.Print(v, " ")
+	}
+	fmt.Println() // 0 1 1 2 3 5 8 13 21 34
+
+	// channel, cancelled after 5 values
+	ctx, cancel := context.WithCancel(context.Background())
+	i := 0
+	for v := range FibChan(ctx, 100) {
+		fmt.Print(v, " ")
+		if i++; i == 5 {
+			cancel()
+			break
+		}
+	}
+	cancel()
+	fmt.Println() // 0 1 1 2 3
+
+	// direct
+	fmt.Println(Fib(10))    // 55 <nil>
+	fmt.Println(Fib(93))    // 12200160415121876738 <nil>
+	fmt.Println(Fib(94))    // 0 fib(94) overflows uint64 (largest is fib(93))
+	fmt.Println(BigFib(94)) // 19740274219868223167 <nil>
+	fmt.Println(BigFib(-1)) // <nil> fib(-1): negative index
+	big_f, _ := BigFib(10000)
+	fmt.Println(len(big_f.String())) // 2090 digits
+
+	// every form agrees up to the overflow point
+	f = fibonacci_closure()
+	n := 0
+	for v := range FibSeq() {
+		w, _ := f()
+		x, _ := Fib(n)
+		y, _ := BigFib(n)
+		if v != w || v != x || y.Uint64() != v {
+			fmt.Println("mismatch at", n)
+		}
+		n++
+	}
+	_, ok := f()
+	fmt.Println(n, ok) // 94 false - F(0) to F(93)
+}
+
+// enumerate pairs each value of seq with its index, like range over a slice
+func enumerate[V any](seq iter.Seq[V]) iter.Seq2[int, V] {
+	return func(yield func(int, V) bool) {
+		i := 0
+		for v := range seq {
+			if !yield(i, v) {
+				return
+			}
+			i++
+		}
+	}
+}
+
 // select
 // select statement lets a goroutine wait on multiple communication operations
 // a select blocks until one of its cases can run, then it executes that case
@@ -582,6 +5905,32 @@ func mutex_test() {
 }
 
 func main() {
+	// go run test.go calc -> expression REPL
+	// go run test.go explain <type> <interface> [dir] -> method sets and interface satisfaction
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "calc":
+			calc_repl(os.Stdin, os.Stdout)
+			return
+		case "explain":
+			if len(os.Args) < 4 {
+				fmt.Fprintln(os.Stderr, "usage: explain <type> <interface> [dir]")
+				os.Exit(2)
+			}
+			dir := "."
+			if len(os.Args) > 4 {
+				dir = os.Args[4]
+			}
+			out, err := Explain(dir, os.Args[2], os.Args[3])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Print(out)
+			return
+		}
+	}
+
 	// fmt.Println("Hello, world!")
 	// fmt.Println("The time is", time.Now())
 	// fmt.Println("My favorite number is", rand.Intn(10))
@@ -827,4 +6176,6 @@ func main() {
 	fmt.Println(compute(math.Pow))
 
 	method_sample()
+
+	// complex_sqrt_test(true)
 }
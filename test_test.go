@@ -0,0 +1,1086 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewtonSqrt(t *testing.T) {
+	tests := []float64{
+		0, 1, 2, 0.25, 1e6, 1e-6,
+		1e308, math.MaxFloat64, // z*z used to overflow
+		5e-324, 1e-310, // subnormals: z*z used to underflow
+	}
+	for _, x := range tests {
+		got, _, err := NewtonSqrt(x, NewtonOptions{})
+		if err != nil {
+			t.Errorf("NewtonSqrt(%v): %v", x, err)
+			continue
+		}
+		if want := math.Sqrt(x); got != want {
+			t.Errorf("NewtonSqrt(%v) = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestSqrt(t *testing.T) {
+	for _, x := range []float64{2, 1e308, math.MaxFloat64, 5e-324} {
+		got, err := Sqrt(x)
+		if want := math.Sqrt(x); got != want || err != nil {
+			t.Errorf("Sqrt(%v) = %v, %v, want %v, <nil>", x, got, err, want)
+		}
+	}
+	if _, err := Sqrt(-2); err != ErrNegativeSqrt(-2) {
+		t.Errorf("Sqrt(-2) error = %v, want ErrNegativeSqrt(-2)", err)
+	}
+}
+
+func TestNthRoot(t *testing.T) {
+	tests := []struct {
+		x    float64
+		n    int
+		want float64
+	}{
+		{1e30, 5, 1e6},
+		{-32, 5, -2},
+		{81, 4, 3},
+		{math.Inf(1), 4, math.Inf(1)},
+		{math.Inf(1), 7, math.Inf(1)},
+		{math.Inf(-1), 5, math.Inf(-1)},
+		{math.MaxFloat64, 5, 4.476546622757235e+61}, // math.Pow alone gives ...1885e+61
+	}
+	for _, tt := range tests {
+		got, err := NthRoot(tt.x, tt.n)
+		if err != nil || math.Abs(got-tt.want) > 1e-15*math.Abs(tt.want) && got != tt.want {
+			t.Errorf("NthRoot(%v, %d) = %v, %v, want %v", tt.x, tt.n, got, err, tt.want)
+		}
+	}
+	if got, err := NthRoot(math.NaN(), 4); !math.IsNaN(got) || err != nil {
+		t.Errorf("NthRoot(NaN, 4) = %v, %v, want NaN, <nil>", got, err)
+	}
+	if _, err := NthRoot(-16, 4); err != ErrNegativeSqrt(-16) {
+		t.Errorf("NthRoot(-16, 4) error = %v, want ErrNegativeSqrt(-16)", err)
+	}
+}
+
+func TestBisectionReversedBounds(t *testing.T) {
+	for _, ab := range [][2]float64{{0, 3}, {3, 0}} {
+		got, _, err := Bisection(math.Cos, ab[0], ab[1], SolverOptions{})
+		if err != nil || math.Abs(got-math.Pi/2) > 1e-9 {
+			t.Errorf("Bisection(cos, %v, %v) = %v, %v, want %v", ab[0], ab[1], got, err, math.Pi/2)
+		}
+	}
+}
+
+// checkArith compares every checked, saturating and wrapping operation on x and y against math/big
+func checkArith[T Integer](t *testing.T, x, y T) {
+	t.Helper()
+	min, max, signed := limits[T]()
+	toBig := func(v T) *big.Int {
+		if signed {
+			return big.NewInt(int64(v))
+		}
+		return new(big.Int).SetUint64(uint64(v))
+	}
+	fromBig := func(b *big.Int) T {
+		if signed {
+			return T(b.Int64())
+		}
+		return T(b.Uint64())
+	}
+	lo, hi := toBig(min), toBig(max)
+	size := new(big.Int).Add(new(big.Int).Sub(hi, lo), big.NewInt(1))
+
+	ops := []struct {
+		name  string
+		exact func(a, b *big.Int) *big.Int
+		check func(T, T) (T, error)
+		sat   func(T, T) T
+		wrap  func(T, T) T
+	}{
+		{"+", new(big.Int).Add, CheckedAdd[T], SaturatingAdd[T], WrappingAdd[T]},
+		{"-", new(big.Int).Sub, CheckedSub[T], SaturatingSub[T], WrappingSub[T]},
+		{"*", new(big.Int).Mul, CheckedMul[T], SaturatingMul[T], WrappingMul[T]},
+		{"/", new(big.Int).Quo, CheckedDiv[T], SaturatingDiv[T], WrappingDiv[T]},
+	}
+	for _, op := range ops {
+		if op.name == "/" && y == 0 {
+			if _, err := op.check(x, y); err != ErrDivideByZero {
+				t.Errorf("CheckedDiv(%v, 0) error = %v, want ErrDivideByZero", x, err)
+			}
+			continue
+		}
+		exact := op.exact(toBig(x), toBig(y))
+		fits := exact.Cmp(lo) >= 0 && exact.Cmp(hi) <= 0
+
+		got, err := op.check(x, y)
+		var overflow *ErrOverflow
+		switch {
+		case fits && (err != nil || got != fromBig(exact)):
+			t.Errorf("Checked %v %s %v = %v, %v, want %v", x, op.name, y, got, err, exact)
+		case !fits && (!errors.As(err, &overflow) || got != 0):
+			t.Errorf("Checked %v %s %v = %v, %v, want overflow", x, op.name, y, got, err)
+		}
+
+		want := exact
+		if exact.Cmp(lo) < 0 {
+			want = lo
+		} else if exact.Cmp(hi) > 0 {
+			want = hi
+		}
+		if got := op.sat(x, y); got != fromBig(want) {
+			t.Errorf("Saturating %v %s %v = %v, want %v", x, op.name, y, got, want)
+		}
+
+		// wrap into [min, max] by adding or subtracting multiples of 2^bits
+		wrapped := new(big.Int).Mod(new(big.Int).Sub(exact, lo), size)
+		wrapped.Add(wrapped, lo)
+		if got := op.wrap(x, y); got != fromBig(wrapped) {
+			t.Errorf("Wrapping %v %s %v = %v, want %v", x, op.name, y, got, wrapped)
+		}
+	}
+}
+
+func exhaustiveArith[T int8 | uint8](t *testing.T) {
+	min, max, _ := limits[T]()
+	for x := int(min); x <= int(max); x++ {
+		for y := int(min); y <= int(max); y++ {
+			checkArith(t, T(x), T(y))
+		}
+	}
+}
+
+// edgeValues are the values around 0, the limits of T and the square root of max, where overflow checks go wrong
+func edgeValues[T Integer]() []T {
+	min, max, signed := limits[T]()
+	r := T(math.Sqrt(float64(max)))
+	vs := []T{0, 1, 2, 3, min, min + 1, min + 2, min / 2, max, max - 1, max / 2, max/2 + 1, r - 1, r, r + 1}
+	if signed {
+		vs = append(vs, ^T(0), ^T(1), 0-r, 0-r-1, min/2-1)
+	}
+	return vs
+}
+
+func edgeArith[T Integer](t *testing.T) {
+	vs := edgeValues[T]()
+	for _, x := range vs {
+		for _, y := range vs {
+			checkArith(t, x, y)
+		}
+	}
+}
+
+func TestIntegerArithExhaustive(t *testing.T) {
+	exhaustiveArith[int8](t)
+	exhaustiveArith[uint8](t)
+}
+
+func TestIntegerArithEdges(t *testing.T) {
+	edgeArith[int16](t)
+	edgeArith[uint16](t)
+	edgeArith[int32](t)
+	edgeArith[uint32](t)
+	edgeArith[int64](t)
+	edgeArith[uint64](t)
+	edgeArith[int](t)
+	edgeArith[uint](t)
+}
+
+func TestSaturatingDivByZeroPanics(t *testing.T) {
+	defer func() {
+		if recover() != ErrDivideByZero {
+			t.Error("SaturatingDiv(1, 0) did not panic with ErrDivideByZero")
+		}
+	}()
+	SaturatingDiv[int8](1, 0)
+}
+
+func TestHistogramNoBounds(t *testing.T) {
+	h := NewHistogram()
+	h.Add(1)
+	h.Add(-1)
+	if got, want := h.String(), "     all        2\n"; got != want {
+		t.Errorf("NewHistogram().String() = %q, want %q", got, want)
+	}
+	if err := h.Merge(NewHistogram(1)); err == nil {
+		t.Error("Merge with different bounds did not fail")
+	}
+}
+
+func TestAccumulatorArgs(t *testing.T) {
+	now := func() time.Time { return time.Time{} }
+	bad := map[string]error{}
+	_, bad["window_sum(-1)"] = window_sum(-1)
+	_, bad["window_sum(0)"] = window_sum(0)
+	_, bad["NewWindowSum(-1)"] = NewWindowSum(-1)
+	_, bad["rate_per_second(0)"] = rate_per_second(0, now)
+	_, bad["NewRatePerSecond(-1s)"] = NewRatePerSecond(-time.Second, now)
+	_, bad["time_window_sum(0)"] = time_window_sum(0, now)
+	_, bad["NewTimeWindowSum(0)"] = NewTimeWindowSum(0, now)
+	_, bad["ewma(0)"] = ewma(0)
+	_, bad["ewma(1.5)"] = ewma(1.5)
+	_, bad["ewma(NaN)"] = ewma(math.NaN())
+	_, bad["NewEWMA(-0.5)"] = NewEWMA(-0.5)
+	for call, err := range bad {
+		if err == nil {
+			t.Errorf("%s: no error", call)
+		}
+	}
+
+	last, err := window_sum(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := last(2) + last(3); got != 5 {
+		t.Errorf("window_sum(1): got %v, want 5", got)
+	}
+	same, err := NewEWMA(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same.Add(4)
+	if got := same.Add(7); got != 7 {
+		t.Errorf("NewEWMA(1): got %v, want 7", got)
+	}
+}
+
+func TestBigFib(t *testing.T) {
+	for n := 0; n <= maxFibUint64; n++ {
+		want, _ := Fib(n)
+		got, err := BigFib(n)
+		if err != nil || !got.IsUint64() || got.Uint64() != want {
+			t.Fatalf("BigFib(%d) = %v, %v, want %d", n, got, err, want)
+		}
+	}
+	if got, err := BigFib(-1); got != nil || err == nil {
+		t.Errorf("BigFib(-1) = %v, %v, want an error", got, err)
+	}
+}
+
+func TestInspectCycles(t *testing.T) {
+	self := []any{nil}
+	self[0] = self
+	a := &node{Name: "a"}
+	a.next = &node{Name: "b", next: a}
+	m := map[string]any{}
+	m["m"] = m
+	arr := []int{1, 2, 3} // two slices of it share an address but not a length
+
+	tests := []struct {
+		name string
+		v    any
+		want []string // substrings, in order
+		not  string
+	}{
+		{"self slice", self, []string{"(cycle)"}, "already shown"},
+		{"pointer cycle", a, []string{"(cycle)"}, "already shown"},
+		{"self map", m, []string{"(cycle)"}, "already shown"},
+		{"shared pointer", []*node{a, a}, []string{"[0]: *main.node", "(cycle)", "[1]: *main.node", "(already shown)"}, ""},
+		{"subslices", [][]int{arr[:1], arr[:2]}, []string{"len=1 cap=3", "len=2 cap=3"}, "already shown"},
+	}
+	for _, tt := range tests {
+		got := Inspect(tt.v)
+		rest := got
+		for _, w := range tt.want {
+			i := strings.Index(rest, w)
+			if i < 0 {
+				t.Errorf("%s: Inspect output is missing %q in order:\n%s", tt.name, w, got)
+				break
+			}
+			rest = rest[i+len(w):]
+		}
+		if tt.not != "" && strings.Contains(got, tt.not) {
+			t.Errorf("%s: Inspect output should not contain %q:\n%s", tt.name, tt.not, got)
+		}
+	}
+}
+
+func TestPersonFormat(t *testing.T) {
+	a := Person{"Arthur Dent", 42}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%v", "Arthur Dent (42 years)"},
+		{"%s", "Arthur Dent (42 years)"},
+		{"%+v", "{Name:Arthur Dent Age:42}"},
+		{"%#v", `main.Person{Name:"Arthur Dent", Age:42}`},
+		{"%q", `"Arthur Dent (42 years)"`},
+		{"%j", `{"name":"Arthur Dent","age":42}`},
+		{"%.6s", "Arthur"},
+		{"%-25s|", "Arthur Dent (42 years)   |"},
+		{"%25v|", "   Arthur Dent (42 years)|"},
+		{"%d", "%!d(main.Person=Arthur Dent (42 years))"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf(tt.format, a); got != tt.want {
+			t.Errorf("Sprintf(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+	if got, want := fmt.Sprintf("%j", Person{`Say "hi"`, 1}), `{"name":"Say \"hi\"","age":1}`; got != want {
+		t.Errorf("Sprintf(%%j) = %q, want %q", got, want)
+	}
+}
+
+func TestStoredPersonFormat(t *testing.T) {
+	r := StoredPerson{1, Person{"Arthur Dent", 42}}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%v", "#1 Arthur Dent (42 years)"},
+		{"%s", "#1 Arthur Dent (42 years)"},
+		{"%-25s|", "#1 Arthur Dent (42 years)   |"},
+		{"%.6v|", "#1 Arthur|"},
+		{"%+v", "{ID:1 Person:{Name:Arthur Dent Age:42}}"},
+		{"%#v", `main.StoredPerson{ID:1, Person:main.Person{Name:"Arthur Dent", Age:42}}`},
+		{"%q", `"Arthur Dent (42 years)"`},
+		{"%j", `{"id":1,"name":"Arthur Dent","age":42}`},
+		{"%d", "%!d(main.Person=Arthur Dent (42 years))"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf(tt.format, r); got != tt.want {
+			t.Errorf("Sprintf(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestPersonStoreRejectsBlankName(t *testing.T) {
+	s := NewPersonStore()
+	for _, name := range []string{"", "   ", "\t\n"} {
+		var verr *ValidationError
+		if _, err := s.Create(Person{name, 30}); !errors.As(err, &verr) || verr.Field != "name" {
+			t.Errorf("Create(Person{%q, 30}) error = %v, want a name ValidationError", name, err)
+		}
+	}
+}
+
+func TestOpenPersonStoreRejectsBadIDs(t *testing.T) {
+	tests := map[string]string{
+		"duplicate": `{"id":1,"name":"Arthur Dent","age":42}` + "\n" + `{"id":1,"name":"Ford Prefect","age":40}` + "\n",
+		"zero":      `{"id":0,"name":"Arthur Dent","age":42}` + "\n",
+		"negative":  `{"id":-3,"name":"Arthur Dent","age":42}` + "\n",
+		"missing":   `{"name":"Arthur Dent","age":42}` + "\n",
+	}
+	for name, data := range tests {
+		path := filepath.Join(t.TempDir(), "people.jsonl")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var verr *ValidationError
+		if _, err := OpenPersonStore(path); !errors.As(err, &verr) || verr.Field != "id" {
+			t.Errorf("%s: OpenPersonStore error = %v, want an id ValidationError", name, err)
+		}
+	}
+}
+
+func TestPersonQueryMaxAgeZero(t *testing.T) {
+	s := NewPersonStore()
+	for _, p := range []Person{{"Baby", 0}, {"Arthur Dent", 42}} {
+		if _, err := s.Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zero := 0
+	if got, err := s.Find(PersonQuery{MaxAge: &zero}); err != nil || len(got) != 1 || got[0].Name != "Baby" {
+		t.Errorf("Find(MaxAge: 0) = %v, %v, want only Baby", got, err)
+	}
+	if got, err := s.Find(PersonQuery{}); err != nil || len(got) != 2 {
+		t.Errorf("Find() = %v, %v, want everyone", got, err)
+	}
+}
+
+func TestReadCSVMissingColumn(t *testing.T) {
+	for _, input := range []string{"name\nArthur Dent\n", "age,email\n42,a@example.com\n", "email\nx\n"} {
+		rows, _, err := ReadCSV[Person](strings.NewReader(input), CSVOptions{})
+		if err == nil || !strings.Contains(err.Error(), "no column for") {
+			t.Errorf("ReadCSV(%q) = %v, %v, want a missing-column error", input, rows, err)
+		}
+	}
+	rows, rowErrs, err := ReadCSV[Person](strings.NewReader("AGE,Name,extra\n42,Arthur Dent,x\n"), CSVOptions{})
+	if err != nil || len(rowErrs) != 0 || len(rows) != 1 || rows[0] != (Person{"Arthur Dent", 42}) {
+		t.Errorf("ReadCSV with reordered columns = %v, %v, %v", rows, rowErrs, err)
+	}
+}
+
+func TestWriteTablesElementTypes(t *testing.T) {
+	a := &Person{"Arthur Dent", 42}
+	writers := map[string]func(*strings.Builder) error{
+		"WriteCSV[*Person]":      func(b *strings.Builder) error { return WriteCSV(b, []*Person{a, nil}, ',') },
+		"WriteTable[*Person]":    func(b *strings.Builder) error { return WriteTable(b, []*Person{a, nil}) },
+		"WriteMarkdown[*Person]": func(b *strings.Builder) error { return WriteMarkdown(b, []*Person{a, nil}) },
+	}
+	want := map[string]string{
+		"WriteCSV[*Person]":      "name,age\nArthur Dent,42\n,\n",
+		"WriteTable[*Person]":    "name         age  string\nArthur Dent  42   Arthur Dent (42 years)\n                  <nil>\n",
+		"WriteMarkdown[*Person]": "| name | age |\n| --- | --- |\n| Arthur Dent | 42 |\n|  |  |\n",
+	}
+	for name, write := range writers {
+		var b strings.Builder
+		if err := write(&b); err != nil || b.String() != want[name] {
+			t.Errorf("%s = %q, %v, want %q", name, b.String(), err, want[name])
+		}
+	}
+
+	var b strings.Builder
+	if err := WriteCSV(&b, []int{1, 2}, ','); err == nil {
+		t.Error("WriteCSV[int]: no error")
+	}
+	if err := WriteMarkdown(&b, []*int{nil}); err == nil {
+		t.Error("WriteMarkdown[*int]: no error")
+	}
+	if err := WriteTable(&b, []time.Duration{time.Second}); err == nil {
+		t.Error("WriteTable[time.Duration]: no error")
+	}
+	if b.Len() != 0 {
+		t.Errorf("rejected writes wrote %q", b.String())
+	}
+}
+
+func TestOrderedBy(t *testing.T) {
+	people := func() []Person {
+		return []Person{{"b", 30}, {"a", 30}, {"c", 20}, {"a", 20}, {"b", 30}}
+	}
+	age := func(a, b *Person) bool { return a.Age < b.Age }
+	name := func(a, b *Person) bool { return a.Name < b.Name }
+
+	s := people()
+	OrderedBy().Sort(s) // no keys: nothing moves, no panic
+	if fmt.Sprint(s) != fmt.Sprint(people()) {
+		t.Errorf("OrderedBy().Sort changed the order: %v", s)
+	}
+
+	// many equal elements, so an unstable sort would be likely to reorder them
+	var big []Person
+	for i := range 200 {
+		big = append(big, Person{fmt.Sprint(i), i % 3})
+	}
+	OrderedBy(age).Sort(big)
+	for i := 1; i < len(big); i++ {
+		a, b := big[i-1], big[i]
+		ai, _ := strconv.Atoi(a.Name)
+		bi, _ := strconv.Atoi(b.Name)
+		if a.Age == b.Age && ai > bi {
+			t.Fatalf("OrderedBy(age).Sort is not stable: %v before %v", a, b)
+		}
+	}
+
+	s = people()
+	OrderedBy(age, name).Sort(s)
+	if got, want := fmt.Sprint(s), "[a (20 years) c (20 years) a (30 years) b (30 years) b (30 years)]"; got != want {
+		t.Errorf("OrderedBy(age, name).Sort = %s, want %s", got, want)
+	}
+}
+
+func TestMyErrorStacks(t *testing.T) {
+	e := NewMyError("x")
+	if e.Line == 0 || len(e.StackTrace()) == 0 {
+		t.Fatalf("NewMyError captured line %d and %d frames, want both", e.Line, len(e.StackTrace()))
+	}
+	if was := SetCaptureStacks(false); !was {
+		t.Error("stack capture was off by default")
+	}
+	e = NewMyError("x")
+	if was := SetCaptureStacks(true); was {
+		t.Error("SetCaptureStacks(false) did not turn capture off")
+	}
+	if e.Line == 0 || e.StackTrace() != nil {
+		t.Errorf("with capture off: line %d and %d frames, want a line and no frames", e.Line, len(e.StackTrace()))
+	}
+
+	// flipping the setting while other goroutines create errors must not race (go test -race)
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if i == 0 {
+					SetCaptureStacks(false)
+					SetCaptureStacks(true)
+				}
+				NewMyError("x")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWithRetry(t *testing.T) {
+	calls := 0
+	flaky := func(x float64) (float64, error) {
+		calls++
+		if calls < 3 {
+			return 0, errors.New("connection reset")
+		}
+		return Sqrt(x)
+	}
+	clock := NewVirtualClock(time.Time{})
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: ExponentialBackoff(time.Second, 0), Clock: clock}
+
+	got, err := Chain(flaky, WithRetry[float64, float64](policy))(4)
+	if got != 2 || err != nil || calls != 3 {
+		t.Errorf("retried flaky(4) = %v, %v after %d calls, want 2, <nil> after 3", got, err, calls)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; !slices.Equal(clock.Slept(), want) {
+		t.Errorf("slept %v, want %v", clock.Slept(), want)
+	}
+
+	calls = 0
+	_, err = Chain(func(x float64) (float64, error) { calls++; return 0, Permanent(ErrNegativeSqrt(x)) },
+		WithRetry[float64, float64](policy))(-1)
+	var rerr *RetryError
+	if !errors.As(err, &rerr) || rerr.Reason != "permanent error" || calls != 1 || !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("permanent error: got %v after %d calls, want one call and a permanent RetryError", err, calls)
+	}
+}
+
+// every helper treats a nil slice like an empty one, and returns nil when the result is empty
+func TestRetryOnlyMaxElapsed(t *testing.T) {
+	fail := errors.New("connection reset")
+	tests := []struct {
+		name    string
+		backoff Backoff
+		calls   int
+		reason  string
+	}{
+		{"nil backoff", nil, 3, "max attempts"},
+		{"zero backoff", ConstantBackoff(0), 1, "zero backoff"},
+		{"backoff reaching zero", func(n int, _ time.Duration) time.Duration { return time.Duration(2-n) * time.Second }, 2, "zero backoff"},
+		{"real backoff", ConstantBackoff(time.Second), 11, "max elapsed time"}, // attempts at 0s, 1s, ... 10s,
+	}
+	for _, tt := range tests {
+		calls := 0
+		clock := NewVirtualClock(time.Time{})
+		done := make(chan error, 1)
+		go func() {
+			done <- Retry(context.Background(), RetryPolicy{MaxElapsed: 10 * time.Second, Backoff: tt.backoff, Clock: clock},
+				func(context.Context) error { calls++; return fail })
+		}()
+		select {
+		case err := <-done:
+			var rerr *RetryError
+			if !errors.As(err, &rerr) || rerr.Reason != tt.reason || calls != tt.calls {
+				t.Errorf("%s: got %v after %d calls, want %q after %d", tt.name, err, calls, tt.reason, tt.calls)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Retry did not return", tt.name)
+		}
+	}
+}
+
+func TestSliceHelpersNil(t *testing.T) {
+	even := func(n int) bool { return n%2 == 0 }
+	for name, s := range map[string][]int{"nil": nil, "empty": {}} {
+		if got := IndexFunc(s, even); got != -1 {
+			t.Errorf("%s: IndexFunc = %d, want -1", name, got)
+		}
+		if got := LastIndex(s, 1); got != -1 {
+			t.Errorf("%s: LastIndex = %d, want -1", name, got)
+		}
+		if IndicesOf(s, 1) != nil || Filter(s, even) != nil || Map(s, strconv.Itoa) != nil || Unique(s) != nil {
+			t.Errorf("%s: IndicesOf, Filter, Map or Unique returned non-nil", name)
+		}
+		if Contains(s, 1) {
+			t.Errorf("%s: Contains = true", name)
+		}
+		if got := Reduce(s, 10, func(a, n int) int { return a + n }); got != 10 {
+			t.Errorf("%s: Reduce = %d, want the initial 10", name, got)
+		}
+		if yes, no := Partition(s, even); yes != nil || no != nil {
+			t.Errorf("%s: Partition = %v, %v, want nil, nil", name, yes, no)
+		}
+		if g := GroupBy(s, even); g == nil || len(g) != 0 {
+			t.Errorf("%s: GroupBy = %v, want an empty map", name, g)
+		}
+		if Chunk(s, 2) != nil || Window(s, 2) != nil || Zip(s, []int{1}) != nil || Zip([]int{1}, s) != nil {
+			t.Errorf("%s: Chunk, Window or Zip returned non-nil", name)
+		}
+		if i, ok := BinarySearch(s, 1); i != 0 || ok {
+			t.Errorf("%s: BinarySearch = %d, %v, want 0, false", name, i, ok)
+		}
+	}
+}
+
+func TestSliceHelpers(t *testing.T) {
+	nums := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3}
+	even := func(n int) bool { return n%2 == 0 }
+	tests := []struct {
+		name string
+		got  any
+		want string
+	}{
+		{"IndexFunc", IndexFunc(nums, even), "2"},
+		{"LastIndex", LastIndex(nums, 5), "8"},
+		{"IndicesOf", IndicesOf(nums, 1), "[1 3]"},
+		{"Filter", Filter(nums, even), "[4 2 6]"},
+		{"Map", Map(nums[:3], strconv.Itoa), "[3 1 4]"},
+		{"Reduce", Reduce(nums, 0, func(a, n int) int { return a + n }), "39"},
+		{"GroupBy", GroupBy(nums, even)[true], "[4 2 6]"},
+		{"Chunk", Chunk(nums, 4), "[[3 1 4 1] [5 9 2 6] [5 3]]"},
+		{"Window", Window(nums[:5], 3), "[[3 1 4] [1 4 1] [4 1 5]]"},
+		{"Zip", Zip([]string{"a", "b", "c"}, []int{1, 2}), "[{a 1} {b 2}]"},
+		{"Unique", Unique(nums), "[3 1 4 5 9 2 6]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.got); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	sorted := slices.Sorted(slices.Values(nums))
+	for x := -1; x <= 10; x++ {
+		i, ok := BinarySearch(sorted, x)
+		wi, wok := slices.BinarySearch(sorted, x)
+		if i != wi || ok != wok {
+			t.Errorf("BinarySearch(%d) = %d, %v, want %d, %v", x, i, ok, wi, wok)
+		}
+	}
+
+	// chunks and windows are capped, so appending to one does not overwrite its neighbour
+	chunks := Chunk(slices.Clone(nums), 4)
+	_ = append(chunks[0], 100)
+	if chunks[1][0] != 5 {
+		t.Errorf("append to a chunk overwrote the next chunk: %v", chunks)
+	}
+}
+
+// benchmarks against the hand-written loops the helpers replace
+// go test -bench . -run ^$
+
+var benchInts = func() []int {
+	s := make([]int, 10_000)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}()
+
+func BenchmarkFilter(b *testing.B) {
+	for b.Loop() {
+		Filter(benchInts, func(n int) bool { return n%2 == 0 })
+	}
+}
+
+func BenchmarkFilterLoop(b *testing.B) {
+	for b.Loop() {
+		var out []int
+		for _, v := range benchInts {
+			if v%2 == 0 {
+				out = append(out, v)
+			}
+		}
+		_ = out
+	}
+}
+
+func BenchmarkMap(b *testing.B) {
+	for b.Loop() {
+		Map(benchInts, func(n int) int { return n * 2 })
+	}
+}
+
+func BenchmarkMapLoop(b *testing.B) {
+	for b.Loop() {
+		out := make([]int, len(benchInts))
+		for i, v := range benchInts {
+			out[i] = v * 2
+		}
+		_ = out
+	}
+}
+
+func BenchmarkContains(b *testing.B) {
+	for b.Loop() {
+		Contains(benchInts, -1)
+	}
+}
+
+func BenchmarkContainsLoop(b *testing.B) {
+	for b.Loop() {
+		found := false
+		for _, v := range benchInts {
+			if v == -1 {
+				found = true
+				break
+			}
+		}
+		_ = found
+	}
+}
+
+func BenchmarkBinarySearch(b *testing.B) {
+	for b.Loop() {
+		BinarySearch(benchInts, 7_777)
+	}
+}
+
+// the linear search BinarySearch replaces on sorted data
+func BenchmarkBinarySearchLoop(b *testing.B) {
+	for b.Loop() {
+		Index(benchInts, 7_777)
+	}
+}
+
+func BenchmarkIndexFunc(b *testing.B) {
+	for b.Loop() {
+		IndexFunc(benchInts, func(n int) bool { return n < 0 })
+	}
+}
+
+func BenchmarkIndexFuncLoop(b *testing.B) {
+	for b.Loop() {
+		at := -1
+		for i, v := range benchInts {
+			if v < 0 {
+				at = i
+				break
+			}
+		}
+		_ = at
+	}
+}
+
+func BenchmarkLastIndex(b *testing.B) {
+	for b.Loop() {
+		LastIndex(benchInts, -1)
+	}
+}
+
+func BenchmarkLastIndexLoop(b *testing.B) {
+	for b.Loop() {
+		at := -1
+		for i := len(benchInts) - 1; i >= 0; i-- {
+			if benchInts[i] == -1 {
+				at = i
+				break
+			}
+		}
+		_ = at
+	}
+}
+
+// tens holds benchInts%10, so 7 is found a thousand times
+func BenchmarkIndicesOf(b *testing.B) {
+	tens := Map(benchInts, func(n int) int { return n % 10 })
+	for b.Loop() {
+		IndicesOf(tens, 7)
+	}
+}
+
+func BenchmarkIndicesOfLoop(b *testing.B) {
+	tens := Map(benchInts, func(n int) int { return n % 10 })
+	for b.Loop() {
+		var out []int
+		for i, v := range tens {
+			if v == 7 {
+				out = append(out, i)
+			}
+		}
+		_ = out
+	}
+}
+
+func BenchmarkReduce(b *testing.B) {
+	for b.Loop() {
+		Reduce(benchInts, 0, func(acc, n int) int { return acc + n })
+	}
+}
+
+func BenchmarkReduceLoop(b *testing.B) {
+	for b.Loop() {
+		sum := 0
+		for _, v := range benchInts {
+			sum += v
+		}
+		_ = sum
+	}
+}
+
+func BenchmarkGroupBy(b *testing.B) {
+	for b.Loop() {
+		GroupBy(benchInts, func(n int) int { return n % 10 })
+	}
+}
+
+func BenchmarkGroupByLoop(b *testing.B) {
+	for b.Loop() {
+		out := make(map[int][]int)
+		for _, v := range benchInts {
+			out[v%10] = append(out[v%10], v)
+		}
+		_ = out
+	}
+}
+
+func BenchmarkPartition(b *testing.B) {
+	for b.Loop() {
+		Partition(benchInts, func(n int) bool { return n%2 == 0 })
+	}
+}
+
+func BenchmarkPartitionLoop(b *testing.B) {
+	for b.Loop() {
+		var yes, no []int
+		for _, v := range benchInts {
+			if v%2 == 0 {
+				yes = append(yes, v)
+			} else {
+				no = append(no, v)
+			}
+		}
+		_, _ = yes, no
+	}
+}
+
+func BenchmarkChunk(b *testing.B) {
+	for b.Loop() {
+		Chunk(benchInts, 16)
+	}
+}
+
+func BenchmarkChunkLoop(b *testing.B) {
+	for b.Loop() {
+		var out [][]int
+		for i := 0; i < len(benchInts); i += 16 {
+			end := min(i+16, len(benchInts))
+			out = append(out, benchInts[i:end:end])
+		}
+		_ = out
+	}
+}
+
+func BenchmarkWindow(b *testing.B) {
+	for b.Loop() {
+		Window(benchInts, 16)
+	}
+}
+
+func BenchmarkWindowLoop(b *testing.B) {
+	for b.Loop() {
+		var out [][]int
+		for i := 0; i+16 <= len(benchInts); i++ {
+			out = append(out, benchInts[i:i+16:i+16])
+		}
+		_ = out
+	}
+}
+
+func BenchmarkZip(b *testing.B) {
+	for b.Loop() {
+		Zip(benchInts, benchInts)
+	}
+}
+
+func BenchmarkZipLoop(b *testing.B) {
+	for b.Loop() {
+		out := make([]Pair[int, int], len(benchInts))
+		for i, v := range benchInts {
+			out[i] = Pair[int, int]{v, benchInts[i]}
+		}
+		_ = out
+	}
+}
+
+// ten distinct values, so most of the work is the seen lookups
+func BenchmarkUnique(b *testing.B) {
+	tens := Map(benchInts, func(n int) int { return n % 10 })
+	for b.Loop() {
+		Unique(tens)
+	}
+}
+
+func BenchmarkUniqueLoop(b *testing.B) {
+	tens := Map(benchInts, func(n int) int { return n % 10 })
+	for b.Loop() {
+		var out []int
+		seen := make(map[int]bool)
+		for _, v := range tens {
+			if !seen[v] {
+				seen[v] = true
+				out = append(out, v)
+			}
+		}
+		_ = out
+	}
+}
+
+func TestNewtonSqrtMatchesMath(t *testing.T) {
+	n := 2_000_000
+	if testing.Short() {
+		n = 100_000
+	}
+	r := rand.New(rand.NewPCG(1, 2))
+	for range n {
+		// random bit patterns cover every exponent, subnormals included
+		x := math.Float64frombits(r.Uint64() &^ (1 << 63))
+		if math.IsNaN(x) {
+			continue
+		}
+		got, err := Sqrt(x)
+		if want := math.Sqrt(x); err != nil || got != want {
+			t.Fatalf("Sqrt(%v) = %v, %v, want %v", x, got, err, want)
+		}
+	}
+}
+
+var roundingModes = []big.RoundingMode{
+	big.ToNearestEven, big.ToNearestAway, big.ToZero, big.AwayFromZero, big.ToNegativeInf, big.ToPositiveInf,
+}
+
+// checkSqrtRounding checks that got is sqrt(x) correctly rounded to prec bits in mode, using only exact
+// rational arithmetic: got is correct if sqrt(x) lies in got's rounding interval, which is decided by squaring its ends
+func checkSqrtRounding(t *testing.T, x *big.Rat, prec uint, mode big.RoundingMode, got *big.Float) {
+	t.Helper()
+	if got.Prec() != prec {
+		t.Errorf("sqrt(%v) has prec %d, want %d", x, got.Prec(), prec)
+	}
+	r, _ := got.Rat(nil)
+	if x.Sign() == 0 {
+		if r.Sign() != 0 {
+			t.Errorf("sqrt(0) = %v", got)
+		}
+		return
+	}
+	sq := func(v *big.Rat) *big.Rat { return new(big.Rat).Mul(v, v) }
+	e := got.MantExp(nil)
+	ulp, _ := new(big.Float).SetMantExp(big.NewFloat(1), e-int(prec)).Rat(nil) // 2^(e-prec)
+	ulpBelow := new(big.Rat).Set(ulp)
+	if m, _ := new(big.Float).SetMantExp(got, -e).Float64(); m == 0.5 {
+		ulpBelow.Quo(ulpBelow, big.NewRat(2, 1)) // a power of two: the float below is closer
+	}
+	next := new(big.Rat).Add(r, ulp)
+	prev := new(big.Rat).Sub(r, ulpBelow)
+
+	ok := true
+	switch mode {
+	case big.ToZero, big.ToNegativeInf: // largest f with f*f <= x
+		ok = sq(r).Cmp(x) <= 0 && sq(next).Cmp(x) > 0
+	case big.AwayFromZero, big.ToPositiveInf: // smallest f with f*f >= x
+		ok = sq(r).Cmp(x) >= 0 && sq(prev).Cmp(x) < 0
+	default:
+		midUp := sq(new(big.Rat).Quo(new(big.Rat).Add(r, next), big.NewRat(2, 1)))
+		midDown := sq(new(big.Rat).Quo(new(big.Rat).Add(r, prev), big.NewRat(2, 1)))
+		ok = midDown.Cmp(x) <= 0 && x.Cmp(midUp) <= 0
+		even := new(big.Rat).Quo(r, ulp).Num().Bit(0) == 0 // r / ulp is the integer mantissa
+		switch {
+		case x.Cmp(midUp) == 0: // tie with the float above
+			ok = ok && mode == big.ToNearestEven && even
+		case x.Cmp(midDown) == 0: // tie with the float below
+			ok = ok && (mode == big.ToNearestAway || even)
+		}
+	}
+	if !ok {
+		t.Errorf("sqrt(%v) at prec %d, %v = %v, not correctly rounded", x, prec, mode, got.Text('g', 20))
+	}
+}
+
+func TestBigRatSqrtRounding(t *testing.T) {
+	for p := int64(1); p <= 90; p++ {
+		for q := int64(1); q <= 90; q++ {
+			x := big.NewRat(p, q)
+			for _, mode := range roundingModes {
+				got, err := BigRatSqrt(x, 8, mode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkSqrtRounding(t, x, 8, mode, got)
+			}
+		}
+	}
+}
+
+func TestBigSqrtRounding(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	for range 2000 {
+		// inputs with many more bits than the result, near perfect squares and powers of two
+		b := make([]byte, 50)
+		for i := range b {
+			b[i] = byte(r.Uint32())
+		}
+		m := new(big.Int).SetBytes(b)
+		x := new(big.Float).SetPrec(400).SetInt(m)
+		x.SetMantExp(x, -398+r.IntN(8))
+		if r.IntN(2) == 0 {
+			x.Sub(big.NewFloat(4).SetPrec(400), new(big.Float).SetMantExp(big.NewFloat(1), -300+r.IntN(100)))
+		}
+		xr, _ := x.Rat(nil)
+		for _, prec := range []uint{1, 2, 10, 53} {
+			for _, mode := range roundingModes {
+				got, err := BigSqrt(x, prec, mode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkSqrtRounding(t, xr, prec, mode, got)
+			}
+		}
+	}
+}
+
+func TestBigSqrtKnownDigits(t *testing.T) {
+	four := new(big.Float).SetPrec(400).SetInt64(4)
+	nearFour := new(big.Float).SetPrec(400).Sub(four, new(big.Float).SetMantExp(big.NewFloat(1), -300))
+
+	tests := []struct {
+		x    any // *big.Float or *big.Rat
+		prec uint
+		want map[big.RoundingMode]string
+	}{
+		{big.NewFloat(2), 10, map[big.RoundingMode]string{
+			big.ToNearestEven: "1.4140625", big.ToNearestAway: "1.4140625",
+			big.ToZero: "1.4140625", big.ToNegativeInf: "1.4140625",
+			big.AwayFromZero: "1.416015625", big.ToPositiveInf: "1.416015625",
+		}},
+		{big.NewRat(1, 6), 8, map[big.RoundingMode]string{ // sqrt = 0.40824829..., 209.02/512
+			big.ToNearestEven: "0.408203125", big.ToNearestAway: "0.408203125",
+			big.ToZero: "0.408203125", big.ToNegativeInf: "0.408203125",
+			big.AwayFromZero: "0.41015625", big.ToPositiveInf: "0.41015625",
+		}},
+		{big.NewRat(1, 7), 8, map[big.RoundingMode]string{ // sqrt = 0.37796447..., 193.52/512
+			big.ToNearestEven: "0.37890625", big.ToNearestAway: "0.37890625",
+			big.ToZero: "0.376953125", big.ToNegativeInf: "0.376953125",
+			big.AwayFromZero: "0.37890625", big.ToPositiveInf: "0.37890625",
+		}},
+		{nearFour, 10, map[big.RoundingMode]string{ // just below 2
+			big.ToNearestEven: "2", big.ToNearestAway: "2",
+			big.ToZero: "1.998046875", big.ToNegativeInf: "1.998046875",
+			big.AwayFromZero: "2", big.ToPositiveInf: "2",
+		}},
+		{big.NewRat(9, 4), 4, map[big.RoundingMode]string{ // exact
+			big.ToNearestEven: "1.5", big.ToNearestAway: "1.5", big.ToZero: "1.5",
+			big.AwayFromZero: "1.5", big.ToNegativeInf: "1.5", big.ToPositiveInf: "1.5",
+		}},
+	}
+	for _, tt := range tests {
+		for _, mode := range roundingModes {
+			var got *big.Float
+			var err error
+			switch x := tt.x.(type) {
+			case *big.Float:
+				got, err = BigSqrt(x, tt.prec, mode)
+			case *big.Rat:
+				got, err = BigRatSqrt(x, tt.prec, mode)
+			}
+			if err != nil || got.Text('g', 20) != tt.want[mode] {
+				t.Errorf("sqrt(%v) at prec %d, %v = %v, %v, want %s", tt.x, tt.prec, mode, got, err, tt.want[mode])
+			}
+		}
+	}
+
+	// a zero-value big.Float has no precision; the result gets the default
+	if got, _ := BigSqrt(new(big.Float), 0, big.ToNearestEven); got.Prec() != defaultBigPrec || got.Sign() != 0 {
+		t.Errorf("BigSqrt(zero value) = %v with prec %d, want 0 with prec %d", got, got.Prec(), defaultBigPrec)
+	}
+}
@@ -0,0 +1,13 @@
+// typednil runs the typednil Analyzer on its own or as a vet tool:
+//
+//	go run ./cmd/typednil ./...
+//	go vet -vettool=$(which typednil) ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/roquitovalmoja/tour-of-Go-compiled/typednil"
+)
+
+func main() { singlechecker.Main(typednil.Analyzer) }
@@ -0,0 +1,163 @@
+package a
+
+import "errors"
+
+type E struct{}
+
+func (*E) Error() string { return "E" }
+
+func declaredWithoutValue(fail bool) error {
+	var e *E
+	if fail {
+		e = &E{}
+	}
+	return e // want `returning variable e of type \*E as error: when it is nil the result is not == nil`
+}
+
+func declaredNil() error {
+	var e *E = nil
+	return e // want `returning variable e of type \*E as error`
+}
+
+func declaredNilConversion() error {
+	e := (*E)(nil)
+	return e // want `returning variable e of type \*E as error`
+}
+
+func assignedNil(e *E) error {
+	e = nil
+	return e // want `returning variable e of type \*E as error`
+}
+
+func nilConversion() error {
+	return (*E)(nil) // want `returning a nil \*E as error: the result is never == nil`
+}
+
+func parameter(p *E) error {
+	return p // want `returning variable p of type \*E as error`
+}
+
+func guardedByReturn(p *E) error {
+	if p == nil {
+		return nil
+	}
+	return p
+}
+
+func guardedByPanic(p *E) error {
+	if nil == p {
+		panic("nil p")
+	}
+	return p
+}
+
+func guardedInside(p *E) error {
+	if p != nil {
+		return p
+	}
+	return nil
+}
+
+func reassignedAfterGuard(p *E) error {
+	if p == nil {
+		return nil
+	}
+	p = nil
+	return p // want `returning variable p of type \*E as error`
+}
+
+func guardedByDefault(p *E) error {
+	if p == nil {
+		p = &E{}
+	}
+	return p
+}
+
+func guardedByNew(p *E, q *E) error {
+	if p == nil {
+		p = q
+		p = new(E)
+	}
+	return p
+}
+
+func defaultMayBeNil(p, q *E) error {
+	if p == nil {
+		p = q
+	}
+	return p // want `returning variable p of type \*E as error`
+}
+
+func defaultMaybeSkipped(p *E, ok bool) error {
+	if p == nil {
+		if ok {
+			p = &E{}
+		}
+	}
+	return p // want `returning variable p of type \*E as error`
+}
+
+func defaultThenCleared(p *E, ok bool) error {
+	if p == nil {
+		p = &E{}
+		if ok {
+			p = nil
+		}
+	}
+	return p // want `returning variable p of type \*E as error`
+}
+
+func defaultWithElse(p *E, ok bool) error {
+	if p == nil {
+		p = &E{}
+	} else if ok {
+		p = nil
+	}
+	return p // want `returning variable p of type \*E as error`
+}
+
+func defaultMap() any {
+	var m map[string]int
+	if m == nil {
+		m = make(map[string]int)
+	}
+	return m
+}
+
+func guardOnlyCoversItsBlock(p *E, ok bool) error {
+	if ok {
+		if p == nil {
+			return nil
+		}
+	}
+	return p // want `returning variable p of type \*E as error`
+}
+
+func neverNil() error {
+	e := &E{}
+	f := new(E)
+	if e == f {
+		return f
+	}
+	return e
+}
+
+func interfaces() error {
+	var err error
+	if err == nil {
+		err = errors.New("x")
+	}
+	return err
+}
+
+func maps() any {
+	var m map[string]int
+	return m // want `returning variable m of type map\[string\]int as any`
+}
+
+func literal() func() error {
+	return func() error {
+		var e *E
+		return e // want `returning variable e of type \*E as error`
+	}
+}
@@ -0,0 +1,304 @@
+// Package typednil defines an Analyzer that reports the typed-nil bug
+//
+// an interface is nil only when both its type and its value are nil,
+// so returning a nil *T (or map, slice, channel or func) as an error gives (nil, *T), which is != nil:
+//
+//	func run() error {
+//		var e *MyError
+//		...
+//		return e // never == nil, even when e is
+//	}
+//
+// only values that may actually be nil are reported: variables declared without a value,
+// set to nil or to a nil conversion like (*T)(nil), and parameters and results,
+// unless a nil check rules nil out first: `if e == nil { return nil }` does,
+// and so does `if e == nil { e = &T{} }` when the last assignment in the braces is a value that cannot be nil
+package typednil
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name: "typednil",
+	Doc:  "report nil pointers, maps, slices, channels and funcs returned through an interface result",
+	Run:  run,
+}
+
+// guard is a stretch of code where obj is known not to be nil
+type guard struct {
+	obj      types.Object
+	from, to token.Pos
+}
+
+type checker struct {
+	pass     *analysis.Pass
+	maybeNil map[types.Object]bool
+	assigns  map[types.Object][]token.Pos // where each variable is assigned
+	guards   []guard
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	c := &checker{
+		pass:     pass,
+		maybeNil: make(map[types.Object]bool),
+		assigns:  make(map[types.Object][]token.Pos),
+	}
+	for _, f := range pass.Files {
+		c.collect(f)
+	}
+	for _, f := range pass.Files {
+		c.walk(f, nil)
+	}
+	return nil, nil
+}
+
+// object returns the variable id declares or refers to
+func (c *checker) object(id *ast.Ident) types.Object {
+	if obj := c.pass.TypesInfo.Defs[id]; obj != nil {
+		return obj
+	}
+	return c.pass.TypesInfo.Uses[id]
+}
+
+// isNil reports whether e is nil or a conversion of nil such as (*T)(nil)
+func (c *checker) isNil(e ast.Expr) bool {
+	e = ast.Unparen(e)
+	if c.pass.TypesInfo.Types[e].IsNil() {
+		return true
+	}
+	call, ok := e.(*ast.CallExpr)
+	return ok && len(call.Args) == 1 && c.pass.TypesInfo.Types[call.Fun].IsType() && c.isNil(call.Args[0])
+}
+
+// collect finds the variables that may be nil, where variables are assigned and the nil checks that guard them
+func (c *checker) collect(f *ast.File) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			for i, id := range n.Names {
+				// var e *E, var e *E = nil, var e = (*E)(nil)
+				if len(n.Values) == 0 || len(n.Values) == len(n.Names) && c.isNil(n.Values[i]) {
+					c.maybeNil[c.object(id)] = true
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				id, ok := ast.Unparen(lhs).(*ast.Ident)
+				if !ok || id.Name == "_" {
+					continue
+				}
+				obj := c.object(id)
+				c.assigns[obj] = append(c.assigns[obj], n.Pos())
+				if len(n.Lhs) == len(n.Rhs) && c.isNil(n.Rhs[i]) {
+					c.maybeNil[obj] = true
+				}
+			}
+		case *ast.FuncType:
+			for _, list := range []*ast.FieldList{n.Params, n.Results} {
+				if list == nil {
+					continue
+				}
+				for _, field := range list.List {
+					for _, id := range field.Names {
+						c.maybeNil[c.object(id)] = true
+					}
+				}
+			}
+		case *ast.BlockStmt:
+			c.collectGuards(n.List, n.End())
+		case *ast.CaseClause:
+			c.collectGuards(n.Body, n.End())
+		case *ast.CommClause:
+			c.collectGuards(n.Body, n.End())
+		}
+		return true
+	})
+}
+
+// collectGuards looks for nil checks among stmts, a statement list that ends at end
+//
+//	if x == nil { return ... }  // x is not nil from here to end
+//	if x == nil { x = &T{} }    // the same
+//	if x != nil { ... }         // x is not nil inside the braces
+func (c *checker) collectGuards(stmts []ast.Stmt, end token.Pos) {
+	for _, st := range stmts {
+		ifs, ok := st.(*ast.IfStmt)
+		if !ok {
+			continue
+		}
+		cond, ok := ast.Unparen(ifs.Cond).(*ast.BinaryExpr)
+		if !ok || cond.Op != token.EQL && cond.Op != token.NEQ {
+			continue
+		}
+		x := cond.X
+		if c.isNil(x) {
+			x = cond.Y
+		} else if !c.isNil(cond.Y) {
+			continue
+		}
+		id, ok := ast.Unparen(x).(*ast.Ident)
+		if !ok {
+			continue
+		}
+		obj := c.object(id)
+		switch {
+		case cond.Op == token.NEQ:
+			c.guards = append(c.guards, guard{obj, ifs.Body.Pos(), ifs.Body.End()})
+		case ifs.Else == nil && (terminates(ifs.Body) || c.setsNonNil(ifs.Body, obj)):
+			c.guards = append(c.guards, guard{obj, ifs.End(), end})
+		}
+	}
+}
+
+// setsNonNil reports whether the last assignment to obj in b is a statement of b itself,
+// not one inside a nested if or loop that might not run, and gives obj a value that cannot be nil
+func (c *checker) setsNonNil(b *ast.BlockStmt, obj types.Object) bool {
+	var last *ast.AssignStmt
+	var value ast.Expr
+	ast.Inspect(b, func(n ast.Node) bool {
+		as, ok := n.(*ast.AssignStmt)
+		if !ok {
+			return true
+		}
+		for i, lhs := range as.Lhs {
+			if id, ok := ast.Unparen(lhs).(*ast.Ident); ok && c.object(id) == obj {
+				last, value = as, nil
+				if len(as.Lhs) == len(as.Rhs) {
+					value = as.Rhs[i]
+				}
+			}
+		}
+		return true
+	})
+	if last == nil || value == nil {
+		return false
+	}
+	for _, st := range b.List {
+		if st == last {
+			return c.nonNil(value)
+		}
+	}
+	return false
+}
+
+// nonNil reports whether e can never be nil: &T{}, a composite or func literal, or a call to new or make
+func (c *checker) nonNil(e ast.Expr) bool {
+	switch e := ast.Unparen(e).(type) {
+	case *ast.UnaryExpr:
+		return e.Op == token.AND
+	case *ast.CompositeLit, *ast.FuncLit:
+		return true
+	case *ast.CallExpr:
+		id, ok := ast.Unparen(e.Fun).(*ast.Ident)
+		if !ok {
+			return false
+		}
+		b, ok := c.pass.TypesInfo.Uses[id].(*types.Builtin)
+		return ok && (b.Name() == "new" || b.Name() == "make")
+	}
+	return false
+}
+
+// terminates reports whether a block always leaves the statement list it is in
+func terminates(b *ast.BlockStmt) bool {
+	if len(b.List) == 0 {
+		return false
+	}
+	switch last := b.List[len(b.List)-1].(type) {
+	case *ast.ReturnStmt, *ast.BranchStmt:
+		return true
+	case *ast.ExprStmt:
+		call, ok := last.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		id, ok := call.Fun.(*ast.Ident)
+		return ok && id.Name == "panic"
+	}
+	return false
+}
+
+// guarded reports whether a nil check covers obj at pos, with no assignment to obj in between
+func (c *checker) guarded(obj types.Object, pos token.Pos) bool {
+	for _, g := range c.guards {
+		if g.obj != obj || pos < g.from || pos >= g.to {
+			continue
+		}
+		reassigned := false
+		for _, a := range c.assigns[obj] {
+			if a >= g.from && a < pos {
+				reassigned = true
+				break
+			}
+		}
+		if !reassigned {
+			return true
+		}
+	}
+	return false
+}
+
+// walk checks the return statements in n against sig, the signature of the enclosing function
+// nested functions are walked with their own signature
+func (c *checker) walk(n ast.Node, sig *types.Signature) {
+	ast.Inspect(n, func(m ast.Node) bool {
+		switch m := m.(type) {
+		case *ast.FuncDecl:
+			if fn, ok := c.pass.TypesInfo.Defs[m.Name].(*types.Func); ok && m.Body != nil {
+				c.walk(m.Body, fn.Type().(*types.Signature))
+			}
+			return false
+		case *ast.FuncLit:
+			s, _ := c.pass.TypesInfo.Types[m].Type.(*types.Signature)
+			c.walk(m.Body, s)
+			return false
+		case *ast.ReturnStmt:
+			if sig != nil {
+				c.checkReturn(sig, m)
+			}
+		}
+		return true
+	})
+}
+
+func (c *checker) checkReturn(sig *types.Signature, ret *ast.ReturnStmt) {
+	results := sig.Results()
+	if len(ret.Results) != results.Len() {
+		return // naked return or return f() with several results
+	}
+	for i, expr := range ret.Results {
+		want := results.At(i).Type()
+		tv := c.pass.TypesInfo.Types[expr]
+		if !types.IsInterface(want) || tv.Type == nil || tv.IsNil() || types.IsInterface(tv.Type) {
+			continue
+		}
+		switch tv.Type.Underlying().(type) {
+		case *types.Pointer, *types.Map, *types.Slice, *types.Chan, *types.Signature:
+		default:
+			continue // structs, numbers and strings can never be nil
+		}
+		q := types.RelativeTo(c.pass.Pkg)
+		typ, iface := types.TypeString(tv.Type, q), types.TypeString(want, q)
+		if c.isNil(expr) {
+			c.pass.Reportf(expr.Pos(), "returning a nil %s as %s: the result is never == nil; return nil instead", typ, iface)
+			continue
+		}
+		id, ok := ast.Unparen(expr).(*ast.Ident)
+		if !ok {
+			continue
+		}
+		if obj := c.pass.TypesInfo.Uses[id]; c.maybeNil[obj] && !c.guarded(obj, expr.Pos()) {
+			c.pass.Report(analysis.Diagnostic{
+				Pos: expr.Pos(),
+				Message: fmt.Sprintf("returning variable %s of type %s as %s: when it is nil the result is not == nil; return nil explicitly",
+					id.Name, typ, iface),
+			})
+		}
+	}
+}
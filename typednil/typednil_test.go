@@ -0,0 +1,13 @@
+package typednil_test
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+
+	"github.com/roquitovalmoja/tour-of-Go-compiled/typednil"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), typednil.Analyzer, "a")
+}